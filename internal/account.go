@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Account 使用 UUID 字符串作为主键，而不是自增整数
+// 非整数主键不会由数据库生成，需要在 BeforeCreate 中填充
+type Account struct {
+	ID        string `gorm:"type:char(36);primaryKey"`
+	Name      string
+	CreatedAt int64 `gorm:"autoCreateTime"`
+}
+
+// BeforeCreate 主键为空时生成 UUID
+func (a *Account) BeforeCreate(tx *gorm.DB) (err error) {
+	if a.ID == "" {
+		a.ID = uuid.NewString()
+	}
+	return
+}
+
+func createAccount(gormDb *gorm.DB, name string) (*Account, error) {
+	account := &Account{Name: name}
+	if err := gormDb.Create(account).Error; err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func getAccount(gormDb *gorm.DB, id string) (*Account, error) {
+	account := new(Account)
+	// 字符串主键需要写成条件，First(account, id) 会把 id 拼接成 SQL
+	if err := gormDb.First(account, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func testAccount(gormDb *gorm.DB) {
+	// INSERT INTO `t_accounts` (`id`,`name`,`created_at`) VALUES ('0e8a4b5c-...','sharpe-account',1641373000)
+	account, err := createAccount(gormDb, "sharpe-account")
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if _, err = uuid.Parse(account.ID); err != nil {
+		fmt.Printf("account.ID %q is not a valid uuid: %v\n", account.ID, err)
+		return
+	}
+
+	readAccount, err := getAccount(gormDb, account.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if readAccount.ID != account.ID {
+		fmt.Printf("expect id %s, got %s\n", account.ID, readAccount.ID)
+		return
+	}
+	fmt.Printf("readAccount = %+v\n", readAccount)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+type actorKey struct{}
+
+// WithActor 把当前操作人放到 ctx 中，配合 db.WithContext 使用
+func WithActor(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, actorKey{}, id)
+}
+
+func actorFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(actorKey{}).(string)
+	return id, ok && id != ""
+}
+
+// registerAuditCallbacks 创建时填充 CreatedBy 和 UpdatedBy，更新时只填充 UpdatedBy，模型没有这两个字段或 ctx 中没有操作人时跳过
+// SetColumn 的最后一个参数为 true 时批量创建的每一条记录都会被设置
+func registerAuditCallbacks(db *gorm.DB) error {
+	setActor := func(tx *gorm.DB, fields ...string) {
+		actor, ok := actorFrom(tx.Statement.Context)
+		if !ok || tx.Statement.Schema == nil {
+			return
+		}
+		for _, name := range fields {
+			if tx.Statement.Schema.LookUpField(name) != nil {
+				tx.Statement.SetColumn(name, actor, true)
+			}
+		}
+	}
+
+	err := db.Callback().Create().Before("gorm:create").Register("audit:before_create", func(tx *gorm.DB) {
+		setActor(tx, "CreatedBy", "UpdatedBy")
+	})
+	if err != nil {
+		return err
+	}
+	return db.Callback().Update().Before("gorm:update").Register("audit:before_update", func(tx *gorm.DB) {
+		setActor(tx, "UpdatedBy")
+	})
+}
+
+type traceIDKey struct{}
+
+// WithTraceID 把关联 ID 放到 ctx 中，同一个 ctx 写入的记录 TraceID 相同
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// registerTraceIDCallbacks 填充 TraceID，ctx 中有关联 ID 时使用它，否则每次写入生成一个新的 UUID
+// 创建时 TraceID 为空则填充，直接修改结构体的字段，批量创建时只填充为空的记录；
+// 更新时与 UpdatedBy 相同，不管是通过查询出来的结构体还是 Where 条件更新，都用 SetColumn 把这次的关联 ID 加到 SET 中，
+// 覆盖记录原来的 TraceID；Select 限制了更新的字段时不会写入
+func registerTraceIDCallbacks(db *gorm.DB) error {
+	traceID := func(tx *gorm.DB) string {
+		if id, ok := tx.Statement.Context.Value(traceIDKey{}).(string); ok && id != "" {
+			return id
+		}
+		return uuid.NewString()
+	}
+
+	err := db.Callback().Create().Before("gorm:create").Register("trace_id:before_create", func(tx *gorm.DB) {
+		if tx.Statement.Schema == nil {
+			return
+		}
+		field := tx.Statement.Schema.LookUpField("TraceID")
+		if field == nil {
+			return
+		}
+		id := traceID(tx)
+		setIfEmpty := func(value reflect.Value) {
+			if _, zero := field.ValueOf(value); zero {
+				tx.AddError(field.Set(value, id))
+			}
+		}
+		switch tx.Statement.ReflectValue.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < tx.Statement.ReflectValue.Len(); i++ {
+				setIfEmpty(reflect.Indirect(tx.Statement.ReflectValue.Index(i)))
+			}
+		case reflect.Struct:
+			setIfEmpty(tx.Statement.ReflectValue)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return db.Callback().Update().Before("gorm:update").Register("trace_id:before_update", func(tx *gorm.DB) {
+		if tx.Statement.Schema == nil || tx.Statement.Schema.LookUpField("TraceID") == nil {
+			return
+		}
+		tx.Statement.SetColumn("TraceID", traceID(tx), true)
+	})
+}
+
+func testAudit(gormDb *gorm.DB) {
+	aliceCtx := WithActor(context.Background(), "alice")
+	user := User{Name: "sharpe-audit"}
+	if err := gormDb.WithContext(aliceCtx).Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if user.CreatedBy != "alice" || user.UpdatedBy != "alice" {
+		fmt.Printf("expect created and updated by alice, got %q %q\n", user.CreatedBy, user.UpdatedBy)
+		return
+	}
+
+	bobCtx := WithActor(context.Background(), "bob")
+	if err := gormDb.WithContext(bobCtx).Model(&user).Update("age", 21).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	audited := new(User)
+	if err := gormDb.First(audited, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if audited.CreatedBy != "alice" || audited.UpdatedBy != "bob" {
+		fmt.Printf("expect created by alice and updated by bob, got %q %q\n", audited.CreatedBy, audited.UpdatedBy)
+		return
+	}
+}
+
+func testTraceID(gormDb *gorm.DB) {
+	// main 中已经在 gormDb 上调用了 registerTraceIDCallbacks
+	ctx1 := WithTraceID(context.Background(), uuid.NewString())
+	ctx2 := WithTraceID(context.Background(), uuid.NewString())
+	first := User{Name: "sharpe-trace-1"}
+	second := User{Name: "sharpe-trace-2"}
+	other := User{Name: "sharpe-trace-3"}
+	if err := gormDb.WithContext(ctx1).Create(&first).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.WithContext(ctx1).Create(&second).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.WithContext(ctx2).Create(&other).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	var users []User
+	if err := gormDb.Order("id").Find(&users, []uint{first.ID, second.ID, other.ID}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(users) != 3 || users[0].TraceID == "" || users[0].TraceID != users[1].TraceID || users[0].TraceID == users[2].TraceID {
+		fmt.Printf("expect the same trace id in the same context only, got %+v\n", users)
+		return
+	}
+	fmt.Printf("trace ids = %s %s %s\n", users[0].TraceID, users[1].TraceID, users[2].TraceID)
+
+	// 更新时写入这次请求的关联 ID，Where 条件更新与通过结构体更新的结果相同
+	// UPDATE `t_users` SET `age`=age + 1,`trace_id`='...',`update_on`=1641373000 WHERE id IN (1,2) AND `t_users`.`is_deleted` = 0
+	ctx3 := WithTraceID(context.Background(), uuid.NewString())
+	err := gormDb.WithContext(ctx3).Model(&User{}).Where("id IN ?", []uint{first.ID, second.ID}).Update("age", gorm.Expr("age + 1")).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = gormDb.WithContext(ctx3).Model(&other).Update("age", 40).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	want, _ := ctx3.Value(traceIDKey{}).(string)
+	if err = gormDb.Order("id").Find(&users, []uint{first.ID, second.ID, other.ID}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for _, user := range users {
+		if user.TraceID != want {
+			fmt.Printf("expect trace id %s after update, got %+v\n", want, user)
+			return
+		}
+	}
+}
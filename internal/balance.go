@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// adjustBalance 原子地增加或减少余额，amount 为负数时是扣款，余额不足时返回 ErrInsufficientBalance
+// decimal 的参数以字符串传递，MySQL 中字符串参与加法运算会先转换为 DOUBLE，所以需要 CAST 成 DECIMAL
+// UPDATE `t_users` SET `balance`=balance + CAST('0.10' AS DECIMAL(18,2)),`update_on`=1641373000
+// WHERE (id = 1 AND balance + CAST('0.10' AS DECIMAL(18,2)) >= 0) AND `t_users`.`is_deleted` = 0
+func adjustBalance(gormDb *gorm.DB, id uint, amount decimal.Decimal) error {
+	result := gormDb.Model(&User{}).
+		Where("id = ? AND balance + CAST(? AS DECIMAL(18,2)) >= 0", id, amount).
+		Update("balance", gorm.Expr("balance + CAST(? AS DECIMAL(18,2))", amount))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: user %d", ErrInsufficientBalance, id)
+	}
+	return nil
+}
+
+func testBalance(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-balance"}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// 0.1 无法用 float64 精确表示，累加 20 次得到的是 1.9999999999999998
+	amount := decimal.RequireFromString("0.10")
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				errs <- adjustBalance(gormDb, user.ID, amount)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
+	var loaded User
+	if err := gormDb.First(&loaded, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if !loaded.Balance.Equal(decimal.RequireFromString("2.00")) {
+		fmt.Printf("expect balance 2.00, got %s\n", loaded.Balance)
+		return
+	}
+
+	// 扣款超过余额
+	if err := adjustBalance(gormDb, user.ID, decimal.RequireFromString("-2.01")); !errors.Is(err, ErrInsufficientBalance) {
+		fmt.Printf("expect ErrInsufficientBalance, got %v\n", err)
+		return
+	}
+	fmt.Printf("balance = %s\n", loaded.Balance.StringFixed(2))
+}
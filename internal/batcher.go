@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+)
+
+// UserBatcher 缓存待创建的用户，数量达到 size 时自动批量写入，适合持续写入的导入场景
+// 不是并发安全的，多个 goroutine 写入时每个 goroutine 使用自己的 UserBatcher
+type UserBatcher struct {
+	db      *gorm.DB
+	size    int
+	buffer  []User
+	flushes int
+}
+
+func NewUserBatcher(db *gorm.DB, size int) *UserBatcher {
+	if size <= 0 {
+		size = 100
+	}
+	return &UserBatcher{db: db, size: size, buffer: make([]User, 0, size)}
+}
+
+// Add 加入一个用户，缓存满了时立即写入，写入失败时返回错误且缓存中的数据保留
+func (b *UserBatcher) Add(u User) error {
+	b.buffer = append(b.buffer, u)
+	if len(b.buffer) >= b.size {
+		return b.Flush()
+	}
+	return nil
+}
+
+// Flush 写入缓存中的所有用户
+func (b *UserBatcher) Flush() error {
+	if len(b.buffer) == 0 {
+		return nil
+	}
+	if err := b.db.CreateInBatches(&b.buffer, b.size).Error; err != nil {
+		return err
+	}
+	b.flushes++
+	b.buffer = b.buffer[:0]
+	return nil
+}
+
+// Close 写入剩余的用户
+func (b *UserBatcher) Close() error {
+	return b.Flush()
+}
+
+func testUserBatcher(gormDb *gorm.DB) {
+	var before int64
+	if err := gormDb.Model(&User{}).Where("name LIKE ?", "sharpe-batcher-%").Count(&before).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	batcher := NewUserBatcher(gormDb, 10)
+	for i := 0; i < 25; i++ {
+		if err := batcher.Add(User{Name: fmt.Sprintf("sharpe-batcher-%d", i)}); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+	// 10 + 10 自动写入，剩下的 5 个在 Close 时写入
+	if err := batcher.Close(); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if batcher.flushes != 3 {
+		fmt.Printf("expect 3 flushes, got %d\n", batcher.flushes)
+		return
+	}
+
+	var after int64
+	if err := gormDb.Model(&User{}).Where("name LIKE ?", "sharpe-batcher-%").Count(&after).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if after-before != 25 {
+		fmt.Printf("expect 25 users persisted, got %d\n", after-before)
+		return
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+type cachedUser struct {
+	user      User
+	expiresAt time.Time
+}
+
+// defaultCacheSize NewCachedRepository 最多缓存的用户数
+const defaultCacheSize = 1000
+
+// CachedRepository 在 UserRepository 外加一层进程内缓存，GetByID 在 ttl 内直接返回缓存，过期或没有缓存时查询数据库并放入缓存，
+// Update/Delete 时删除缓存；最多缓存 size 个用户，超过时淘汰最久没有访问的
+// 缓存的是 User 的浅拷贝，Email、Birthday 等指针字段与缓存共享，调用方不要修改它们指向的值
+type CachedRepository struct {
+	repo *UserRepository
+	ttl  time.Duration
+	size int
+
+	// 命中缓存时也要调整 lru 的顺序，读写都加互斥锁
+	mu sync.Mutex
+	// users 的值是 lru 中的元素，lru 的头部是最近访问的
+	users map[uint]*list.Element
+	lru   *list.List
+}
+
+func NewCachedRepository(r *UserRepository, ttl time.Duration) *CachedRepository {
+	return NewCachedRepositoryWithSize(r, ttl, defaultCacheSize)
+}
+
+// NewCachedRepositoryWithSize 最多缓存 size 个用户，size <= 0 时使用 defaultCacheSize
+func NewCachedRepositoryWithSize(r *UserRepository, ttl time.Duration, size int) *CachedRepository {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &CachedRepository{repo: r, ttl: ttl, size: size, users: make(map[uint]*list.Element), lru: list.New()}
+}
+
+func (c *CachedRepository) GetByID(ctx context.Context, id uint) (*User, error) {
+	if user, ok := c.get(id); ok {
+		return user, nil
+	}
+
+	user, err := c.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.put(*user)
+	return user, nil
+}
+
+// get 在同一次加锁中查找缓存并移到 lru 头部，返回的是缓存的拷贝
+func (c *CachedRepository) get(id uint) (*User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.users[id]
+	if !ok {
+		return nil, false
+	}
+	cached := elem.Value.(*cachedUser)
+	if !time.Now().Before(cached.expiresAt) {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	user := cached.user
+	return &user, true
+}
+
+func (c *CachedRepository) put(user User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cachedUser{user: user, expiresAt: time.Now().Add(c.ttl)}
+	if elem, ok := c.users[user.ID]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.users[user.ID] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.users, oldest.Value.(*cachedUser).user.ID)
+	}
+}
+
+// Update/Delete 在写数据库返回后删除缓存，不管是否成功；写之前删除的话，写完成前并发的 GetByID 会把旧值重新放进缓存
+func (c *CachedRepository) Update(ctx context.Context, user *User, values map[string]interface{}) (int64, error) {
+	defer c.InvalidateUser(user.ID)
+	return c.repo.Update(ctx, user, values)
+}
+
+func (c *CachedRepository) Delete(ctx context.Context, id uint) (int64, error) {
+	defer c.InvalidateUser(id)
+	return c.repo.Delete(ctx, id)
+}
+
+// InvalidateUser 删除用户的缓存，不经过 CachedRepository 修改用户时需要手动调用
+func (c *CachedRepository) InvalidateUser(id uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.users[id]; ok {
+		c.lru.Remove(elem)
+		delete(c.users, id)
+	}
+}
+
+func testCachedRepository(gormDb *gorm.DB) {
+	// 统计查询次数，Repository 会用传入的 ctx 替换 Session 的 ctx，所以使用 countDb 的 ctx
+	countDb, counter, err := countingSession(gormDb)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	ctx := countDb.Statement.Context
+
+	repo := NewCachedRepository(NewUserRepository(countDb), time.Minute)
+	user := User{Name: "sharpe-cached"}
+	if err = repo.repo.Create(ctx, &user); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = repo.GetByID(ctx, user.ID); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+	// 第二次命中缓存
+	if counter.Count("query") != 1 {
+		fmt.Printf("expect 1 query, got %d\n", counter.Count("query"))
+		return
+	}
+
+	// 更新后缓存失效，重新查询拿到新的值
+	if _, err = repo.Update(ctx, &user, map[string]interface{}{"age": 60}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	updated, err := repo.GetByID(ctx, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if counter.Count("query") != 2 || updated.Age != 60 {
+		fmt.Printf("expect a fresh read after update, got %d queries and age %d\n", counter.Count("query"), updated.Age)
+		return
+	}
+
+	// 手动失效
+	repo.InvalidateUser(user.ID)
+	if _, err = repo.GetByID(ctx, user.ID); err != nil || counter.Count("query") != 3 {
+		fmt.Printf("expect a fresh read after InvalidateUser, got %d queries, %v\n", counter.Count("query"), err)
+		return
+	}
+
+	// 过期后重新查询
+	counter.Reset()
+	shortRepo := NewCachedRepository(NewUserRepository(countDb), 50*time.Millisecond)
+	if _, err = shortRepo.GetByID(ctx, user.ID); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err = shortRepo.GetByID(ctx, user.ID); err != nil || counter.Count("query") != 2 {
+		fmt.Printf("expect a fresh read after expiry, got %d queries, %v\n", counter.Count("query"), err)
+		return
+	}
+
+	// 缓存满了淘汰最久没有访问的：访问顺序 first、second、first、third，淘汰 second
+	counter.Reset()
+	lruRepo := NewCachedRepositoryWithSize(NewUserRepository(countDb), time.Minute, 2)
+	users := []User{{Name: "sharpe-cached-lru-1"}, {Name: "sharpe-cached-lru-2"}, {Name: "sharpe-cached-lru-3"}}
+	if err = countDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for _, i := range []int{0, 1, 0, 2} {
+		if _, err = lruRepo.GetByID(ctx, users[i].ID); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+	if counter.Count("query") != 3 {
+		fmt.Printf("expect 3 queries before eviction check, got %d\n", counter.Count("query"))
+		return
+	}
+	if _, err = lruRepo.GetByID(ctx, users[0].ID); err != nil || counter.Count("query") != 3 {
+		fmt.Printf("expect the first user to stay cached, got %d queries, %v\n", counter.Count("query"), err)
+		return
+	}
+	if _, err = lruRepo.GetByID(ctx, users[1].ID); err != nil || counter.Count("query") != 4 {
+		fmt.Printf("expect the second user to be evicted, got %d queries, %v\n", counter.Count("query"), err)
+		return
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// Company 公司，User 属于 Company
+type Company struct {
+	ID   uint
+	Name string `gorm:"size:64"`
+}
+
+// 联合索引：多个字段使用同一个索引名即可，例如 User 的
+//
+//	Name      string `gorm:"size:64;uniqueIndex:idx_company_name"`
+//	CompanyID *uint  `gorm:"uniqueIndex:idx_company_name"`
+//
+// 会生成 UNIQUE INDEX `idx_company_name` (`name`,`company_id`)
+// 索引中字段的顺序默认与结构体字段顺序一致，可以用 priority 调整，例如 `gorm:"uniqueIndex:idx_company_name,priority:1"`
+func testCompanyUniqueName(gormDb *gorm.DB) {
+	if !gormDb.Migrator().HasIndex(&User{}, "idx_company_name") {
+		fmt.Println("index idx_company_name not found")
+		return
+	}
+
+	companies := []Company{{Name: "Acme"}, {Name: "Globex"}}
+	result := gormDb.Create(&companies)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	// 不同公司下可以重名
+	for i := range companies {
+		_, err := createUser(gormDb, &User{Name: "sharpe-company", CompanyID: &companies[i].ID})
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
+	// 同一公司下重名会冲突
+	// Error 1062: Duplicate entry 'sharpe-company-1' for key 'idx_company_name'
+	_, err := createUser(gormDb, &User{Name: "sharpe-company", CompanyID: &companies[0].ID})
+	if !errors.Is(err, ErrDuplicate) {
+		fmt.Printf("expect ErrDuplicate, got %v\n", err)
+		return
+	}
+	fmt.Println(err.Error())
+}
+
+// findUsersOfCompanies 查询公司名匹配 pattern 的所有用户，*gorm.DB 作为参数时会被当作子查询
+// SELECT * FROM `t_users` WHERE company_id IN (SELECT `id` FROM `t_companies` WHERE name LIKE 'Acme%') AND `t_users`.`is_deleted` = 0
+// 子查询的参数同样使用占位符传递，不会拼接到 SQL 中
+func findUsersOfCompanies(gormDb *gorm.DB, pattern string) ([]User, error) {
+	var users []User
+	subQuery := gormDb.Model(&Company{}).Select("id").Where("name LIKE ?", pattern)
+	err := gormDb.Where("company_id IN (?)", subQuery).Find(&users).Error
+	return users, err
+}
+
+func testSubQuery(gormDb *gorm.DB) {
+	sql := explainSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		subQuery := tx.Model(&Company{}).Select("id").Where("name LIKE ?", "Acme%")
+		return tx.Where("company_id IN (?)", subQuery).Find(&[]User{})
+	})
+	if !strings.Contains(sql, "company_id IN (SELECT `id` FROM `t_companies` WHERE name LIKE 'Acme%')") {
+		fmt.Printf("expect a nested SELECT, got %s\n", sql)
+		return
+	}
+
+	acme, globex := Company{Name: "Acme Subquery"}, Company{Name: "Globex Subquery"}
+	result := gormDb.Create([]*Company{&acme, &globex})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	users := []User{
+		{Name: "sharpe-subquery-acme", CompanyID: &acme.ID},
+		{Name: "sharpe-subquery-globex", CompanyID: &globex.ID},
+	}
+	result = gormDb.Create(&users)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	acmeUsers, err := findUsersOfCompanies(gormDb, "Acme%")
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for _, user := range acmeUsers {
+		if user.Name == "sharpe-subquery-globex" {
+			fmt.Printf("unexpected user %+v\n", user)
+			return
+		}
+	}
+	found := false
+	for _, user := range acmeUsers {
+		found = found || user.ID == users[0].ID
+	}
+	if !found {
+		fmt.Printf("expect user %d in %+v\n", users[0].ID, acmeUsers)
+		return
+	}
+	fmt.Printf("acmeUsers len = %d\n", len(acmeUsers))
+}
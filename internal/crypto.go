@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"strings"
+)
+
+// encryptionKey AES 密钥，由 setEncryptionKey 从配置 Crypto.EncryptionKey 中读取
+var encryptionKey []byte
+
+// setEncryptionKey key 为 base64 编码的 16、24 或 32 字节，分别对应 AES-128、AES-192、AES-256
+func setEncryptionKey(key string) error {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("decode encryption key: %w", err)
+	}
+	if _, err = aes.NewCipher(b); err != nil {
+		return err
+	}
+	encryptionKey = b
+	return nil
+}
+
+func newGCM() (cipher.AEAD, error) {
+	if encryptionKey == nil {
+		return nil, errors.New("encryption key is not configured")
+	}
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptedString 写入时用 AES-GCM 加密，读取时解密，数据库中保存的是 base64(nonce + 密文)
+// 每次加密的 nonce 都是随机的，相同的明文得到不同的密文，所以不能用于 WHERE 条件和唯一索引
+// 空字符串保存为 NULL，不需要密钥
+type EncryptedString string
+
+// Value 实现 driver.Valuer 接口
+func (s EncryptedString) Value() (driver.Value, error) {
+	if s == "" {
+		return nil, nil
+	}
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(s), nil)), nil
+}
+
+// Scan 实现 sql.Scanner 接口
+func (s *EncryptedString) Scan(value interface{}) error {
+	var encoded string
+	switch v := value.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case []byte:
+		encoded = string(v)
+	case string:
+		encoded = v
+	default:
+		return fmt.Errorf("scan encrypted string: unsupported type %T", value)
+	}
+	if encoded == "" {
+		*s = ""
+		return nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("scan encrypted string: %w", err)
+	}
+	gcm, err := newGCM()
+	if err != nil {
+		return err
+	}
+	if len(data) < gcm.NonceSize() {
+		return errors.New("scan encrypted string: ciphertext too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return fmt.Errorf("scan encrypted string: %w", err)
+	}
+	*s = EncryptedString(plain)
+	return nil
+}
+
+func testEncryptedEmail(gormDb *gorm.DB) {
+	// 没有配置密钥时使用演示用的密钥
+	if encryptionKey == nil {
+		if err := setEncryptionKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
+	plain := "sharpe-secret@gmail.com"
+	user := User{Name: "sharpe-encrypted", EncryptedEmail: EncryptedString(plain)}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// 数据库中保存的是密文
+	var stored string
+	if err := gormDb.Raw("SELECT encrypted_email FROM t_users WHERE id = ?", user.ID).Scan(&stored).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if stored == "" || strings.Contains(stored, plain) {
+		fmt.Printf("expect ciphertext in database, got %q\n", stored)
+		return
+	}
+
+	var loaded User
+	if err := gormDb.First(&loaded, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if string(loaded.EncryptedEmail) != plain {
+		fmt.Printf("expect %s, got %s\n", plain, loaded.EncryptedEmail)
+		return
+	}
+	fmt.Printf("stored = %s, loaded = %s\n", stored, loaded.EncryptedEmail)
+
+	// 空值保存为 NULL，读出来是空字符串
+	empty := User{Name: "sharpe-encrypted-empty"}
+	if err := gormDb.Create(&empty).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.First(&loaded, empty.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if loaded.EncryptedEmail != "" {
+		fmt.Printf("expect empty encrypted email, got %q\n", loaded.EncryptedEmail)
+		return
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// usersCSVHeader 导出的列，与 User 的字段名一致
+var usersCSVHeader = []string{"ID", "Name", "Email", "Age", "Status", "CreatedAt", "UpdateOn"}
+
+// formatUnix 秒级时间戳转换为 RFC3339，0 表示没有设置，输出空字符串
+func formatUnix(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+// exportUsersCSV 每次查询 500 个用户写入 w，内存中只保留一批
+// SELECT * FROM `t_users` WHERE `t_users`.`is_deleted` = 0 ORDER BY `t_users`.`id` LIMIT 500
+// SELECT * FROM `t_users` WHERE `t_users`.`is_deleted` = 0 AND `t_users`.`id` > 500 ORDER BY `t_users`.`id` LIMIT 500
+func exportUsersCSV(db *gorm.DB, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(usersCSVHeader); err != nil {
+		return err
+	}
+
+	var users []User
+	err := db.FindInBatches(&users, 500, func(tx *gorm.DB, batch int) error {
+		for _, user := range users {
+			email := ""
+			if user.Email != nil {
+				email = *user.Email
+			}
+			record := []string{
+				strconv.FormatUint(uint64(user.ID), 10),
+				user.Name,
+				email,
+				strconv.Itoa(int(user.Age)),
+				user.Status.String(),
+				formatUnix(user.CreatedAt),
+				formatUnix(user.UpdateOn),
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func testExportUsersCSV(gormDb *gorm.DB) {
+	createdAt := time.Date(2022, 1, 5, 8, 0, 0, 0, time.UTC)
+	email := fmt.Sprintf("sharpe-csv-%d@gmail.com", time.Now().UnixNano())
+	user := User{Name: "sharpe-csv", Email: &email, Age: 40, CreatedAt: createdAt.Unix(), UpdateOn: createdAt.Unix()}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := exportUsersCSV(gormDb, &buf); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(records) < 2 || fmt.Sprint(records[0]) != fmt.Sprint(usersCSVHeader) {
+		fmt.Printf("expect header %v, got %v\n", usersCSVHeader, records)
+		return
+	}
+
+	want := []string{strconv.FormatUint(uint64(user.ID), 10), "sharpe-csv", email, "40", "active", "2022-01-05T08:00:00Z", "2022-01-05T08:00:00Z"}
+	for _, record := range records[1:] {
+		if record[0] == want[0] {
+			if fmt.Sprint(record) != fmt.Sprint(want) {
+				fmt.Printf("expect %v, got %v\n", want, record)
+			}
+			return
+		}
+	}
+	fmt.Printf("expect user %d to be exported\n", user.ID)
+}
+
+// RowError CSV 中某一行的错误，Line 从 1 开始，表头是第 1 行
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e RowError) Unwrap() error {
+	return e.Err
+}
+
+// importUsersCSV 导入 exportUsersCSV 格式的 CSV，只读取 Name、Email、Age 列，其余列忽略
+// 不合法的行记录到 errs 中并跳过，合法的行每 100 个一批插入；读取 CSV 或写入数据库失败时返回 err
+// 引号不匹配这类解析错误之后无法继续读取，返回带行号的 RowError，不插入任何数据
+func importUsersCSV(db *gorm.DB, r io.Reader) (imported int, errs []RowError, err error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return 0, nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	if _, ok := columns["Name"]; !ok {
+		return 0, nil, errors.New("missing Name column")
+	}
+	get := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var users []User
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		// 其余的解析错误没有返回字段，FieldPos 会 panic，行号从 ParseError 中读取
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				return 0, errs, RowError{Line: pe.StartLine, Err: pe.Err}
+			}
+			return 0, errs, err
+		}
+		line, _ := cr.FieldPos(0)
+		// 列数与表头不同时跳过这一行
+		if err != nil {
+			errs = append(errs, RowError{Line: line, Err: err})
+			continue
+		}
+
+		user, err := parseUserRecord(get(record, "Name"), get(record, "Email"), get(record, "Age"))
+		if err != nil {
+			errs = append(errs, RowError{Line: line, Err: err})
+			continue
+		}
+		users = append(users, user)
+	}
+
+	if len(users) == 0 {
+		return 0, errs, nil
+	}
+	if err = translateError(db.CreateInBatches(&users, 100).Error); err != nil {
+		return 0, errs, err
+	}
+	return len(users), errs, nil
+}
+
+// parseUserRecord 校验规则与 BeforeUpdate、BeforeSave 相同，Age 为空时由 BeforeCreate 设置默认值
+func parseUserRecord(name, email, age string) (User, error) {
+	if name == "" {
+		return User{}, ErrEmptyName
+	}
+	user := User{Name: name}
+	if email != "" {
+		if !emailRegexp.MatchString(email) {
+			return User{}, fmt.Errorf("%w: %q", ErrInvalidEmail, email)
+		}
+		user.Email = &email
+	}
+	if age != "" {
+		n, err := strconv.ParseUint(age, 10, 8)
+		if err != nil {
+			return User{}, fmt.Errorf("invalid age %q: %w", age, err)
+		}
+		user.Age = uint8(n)
+	}
+	return user, nil
+}
+
+func testImportUsersCSV(gormDb *gorm.DB) {
+	suffix := time.Now().UnixNano()
+	input := fmt.Sprintf(`Name,Email,Age
+sharpe-import-1,sharpe-import-1-%[1]d@gmail.com,21
+sharpe-import-2,,
+,sharpe-import-no-name-%[1]d@gmail.com,30
+sharpe-import-3,not-an-email,30
+sharpe-import-4,,300
+sharpe-import-5
+`, suffix)
+
+	imported, errs, err := importUsersCSV(gormDb, strings.NewReader(input))
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if imported != 2 {
+		fmt.Printf("expect 2 users imported, got %d\n", imported)
+		return
+	}
+	wantLines := []int{4, 5, 6, 7}
+	if len(errs) != len(wantLines) {
+		fmt.Printf("expect errors on lines %v, got %v\n", wantLines, errs)
+		return
+	}
+	for i, rowErr := range errs {
+		if rowErr.Line != wantLines[i] {
+			fmt.Printf("expect error on line %d, got %v\n", wantLines[i], rowErr)
+			return
+		}
+	}
+	if !errors.Is(errs[0], ErrEmptyName) || !errors.Is(errs[1], ErrInvalidEmail) || !errors.Is(errs[3], csv.ErrFieldCount) {
+		fmt.Printf("unexpected errors %v\n", errs)
+		return
+	}
+	for _, rowErr := range errs {
+		fmt.Println(rowErr.Error())
+	}
+
+	// 引号不匹配时不能继续读取，返回出错的行，前面合法的行也不会插入
+	malformed := `Name,Email,Age
+sharpe-import-6,,20
+sharpe-"import-7,,20
+`
+	imported, _, err = importUsersCSV(gormDb, strings.NewReader(malformed))
+	var rowErr RowError
+	if !errors.As(err, &rowErr) || rowErr.Line != 3 || !errors.Is(err, csv.ErrBareQuote) || imported != 0 {
+		fmt.Printf("expect a bare quote error on line 3, got %d imported, %v\n", imported, err)
+		return
+	}
+	// line 3: bare " in non-quoted-field
+	fmt.Println(err.Error())
+}
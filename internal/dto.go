@@ -0,0 +1,349 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// UserDTO 对外返回的用户信息，不暴露 Email、时间戳等内部字段
+type UserDTO struct {
+	ID   uint
+	Name string
+	Age  uint8
+}
+
+func toUserDTO(user User) UserDTO {
+	return UserDTO{ID: user.ID, Name: user.Name, Age: user.Age}
+}
+
+// MembershipDTO 会员信息，sql.NullString、sql.NullTime 直接序列化为 {"String":"","Valid":false}，
+// 转换为指针后没有值时输出 null
+type MembershipDTO struct {
+	ID           uint
+	MemberNumber *string
+	ActivatedAt  *time.Time
+}
+
+func toMembershipDTO(user User) MembershipDTO {
+	dto := MembershipDTO{ID: user.ID}
+	if user.MemberNumber.Valid {
+		dto.MemberNumber = &user.MemberNumber.String
+	}
+	if user.ActivatedAt.Valid {
+		dto.ActivatedAt = &user.ActivatedAt.Time
+	}
+	return dto
+}
+
+// describeMembership 根据 Valid 区分 NULL 和有值，NULL 时 String、Time 是零值，不能直接使用
+func describeMembership(user User) string {
+	if !user.MemberNumber.Valid {
+		return "not a member"
+	}
+	if !user.ActivatedAt.Valid {
+		return fmt.Sprintf("member %s, not activated", user.MemberNumber.String)
+	}
+	return fmt.Sprintf("member %s, activated at %s", user.MemberNumber.String, user.ActivatedAt.Time.Format(time.RFC3339))
+}
+
+// UserInput 接口层接受的用户信息，与 User 的存储细节解耦：可以为空的字段用指针表示，不出现 sql.Null* 类型
+type UserInput struct {
+	Name         string     `json:"name"`
+	Email        *string    `json:"email"`
+	Age          uint8      `json:"age"`
+	MemberNumber *string    `json:"member_number"`
+	ActivatedAt  *time.Time `json:"activated_at"`
+}
+
+// ToModel 校验并转换为 User，Name 不能为空，Email 为空字符串时按没有设置处理，写入 NULL，
+// 否则多个空字符串会违反 Email 的唯一索引
+func (in UserInput) ToModel() (*User, error) {
+	if in.Name == "" {
+		return nil, ErrEmptyName
+	}
+	user := &User{Name: in.Name, Age: in.Age}
+	if in.Email != nil && *in.Email != "" {
+		if !emailRegexp.MatchString(*in.Email) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidEmail, *in.Email)
+		}
+		email := *in.Email
+		user.Email = &email
+	}
+	if in.MemberNumber != nil {
+		user.MemberNumber = sql.NullString{String: *in.MemberNumber, Valid: true}
+	}
+	if in.ActivatedAt != nil {
+		user.ActivatedAt = sql.NullTime{Time: *in.ActivatedAt, Valid: true}
+	}
+	return user, nil
+}
+
+// UserOutput 接口层返回的用户信息，NULL 字段输出 null，秒级时间戳转换为 RFC3339，没有设置时不输出
+type UserOutput struct {
+	ID           uint       `json:"id"`
+	Name         string     `json:"name"`
+	Email        *string    `json:"email"`
+	Age          uint8      `json:"age"`
+	MemberNumber *string    `json:"member_number"`
+	ActivatedAt  *time.Time `json:"activated_at"`
+	CreatedAt    string     `json:"created_at,omitempty"`
+	UpdatedAt    string     `json:"updated_at,omitempty"`
+}
+
+// FromModel 把 User 转换为 UserOutput，u 为 nil 时返回零值
+func FromModel(u *User) UserOutput {
+	if u == nil {
+		return UserOutput{}
+	}
+	out := UserOutput{
+		ID:        u.ID,
+		Name:      u.Name,
+		Email:     u.Email,
+		Age:       u.Age,
+		CreatedAt: formatUnix(u.CreatedAt),
+		UpdatedAt: formatUnix(u.UpdateOn),
+	}
+	if u.MemberNumber.Valid {
+		memberNumber := u.MemberNumber.String
+		out.MemberNumber = &memberNumber
+	}
+	if u.ActivatedAt.Valid {
+		activatedAt := u.ActivatedAt.Time
+		out.ActivatedAt = &activatedAt
+	}
+	return out
+}
+
+// String 实现 fmt.Stringer，%v、%+v 输出 User 时使用，解引用 Email、Birthday，秒级时间戳格式化为 RFC3339，
+// NULL 和没有设置的时间输出 <null>；Company、Profile 等关联只是指针，不输出
+// User{ID:1 Name:sharpe Email:sharpe@example.com Age:20 Birthday:<null> MemberNumber:M-001 ActivatedAt:<null> Status:active CreatedAt:2022-01-05T08:30:00Z UpdateOn:2022-01-05T08:30:00Z}
+func (u User) String() string {
+	const null = "<null>"
+	email, birthday, memberNumber, activatedAt := null, null, null, null
+	if u.Email != nil {
+		email = *u.Email
+	}
+	if u.Birthday != nil {
+		birthday = u.Birthday.Format(time.RFC3339)
+	}
+	if u.MemberNumber.Valid {
+		memberNumber = u.MemberNumber.String
+	}
+	if u.ActivatedAt.Valid {
+		activatedAt = u.ActivatedAt.Time.Format(time.RFC3339)
+	}
+	unix := func(ts int64) string {
+		if ts == 0 {
+			return null
+		}
+		return formatUnix(ts)
+	}
+	return fmt.Sprintf("User{ID:%d Name:%s Email:%s Age:%d Birthday:%s MemberNumber:%s ActivatedAt:%s Status:%s CreatedAt:%s UpdateOn:%s}",
+		u.ID, u.Name, email, u.Age, birthday, memberNumber, activatedAt, u.Status, unix(u.CreatedAt), unix(u.UpdateOn))
+}
+
+// listUserDTOs 只查询需要的列并扫描到 UserDTO
+// SELECT `id`,`name`,`age` FROM `t_users` WHERE `t_users`.`is_deleted` = 0
+func listUserDTOs(gormDb *gorm.DB) ([]UserDTO, error) {
+	var dtos []UserDTO
+	err := gormDb.Model(&User{}).Select("id", "name", "age").Scan(&dtos).Error
+	return dtos, err
+}
+
+func testUserDTO(gormDb *gorm.DB) {
+	sql := explainSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&User{}).Select("id", "name", "age").Scan(&[]UserDTO{})
+	})
+	if !strings.HasPrefix(sql, "SELECT `id`,`name`,`age` FROM") {
+		fmt.Printf("expect only id, name, age to be selected, got %s\n", sql)
+		return
+	}
+
+	dtos, err := listUserDTOs(gormDb)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("dtos len = %d\n", len(dtos))
+
+	// 同样的列扫描到 User 中，没有查询的字段都是零值
+	var users []User
+	err = gormDb.Model(&User{}).Select("id", "name", "age").Scan(&users).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for _, user := range users {
+		if user.Email != nil || user.CreatedAt != 0 || user.UpdateOn != 0 {
+			fmt.Printf("expect email and timestamps to be empty, got %+v\n", user)
+			return
+		}
+	}
+}
+
+func testNullFields(gormDb *gorm.DB) {
+	// MySQL 的 datetime 保存到毫秒，截断后才能比较
+	activatedAt := time.Now().Truncate(time.Second)
+	users := []User{
+		{Name: "sharpe-member", MemberNumber: sql.NullString{String: "M-001", Valid: true}, ActivatedAt: sql.NullTime{Time: activatedAt, Valid: true}},
+		// 零值的 Valid 为 false，写入 NULL
+		{Name: "sharpe-not-member"},
+	}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	var member, notMember User
+	if err := gormDb.First(&member, users[0].ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.First(&notMember, users[1].ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if !member.MemberNumber.Valid || member.MemberNumber.String != "M-001" || !member.ActivatedAt.Valid || !member.ActivatedAt.Time.Equal(activatedAt) {
+		fmt.Printf("expect member M-001 activated at %s, got %+v, %+v\n", activatedAt, member.MemberNumber, member.ActivatedAt)
+		return
+	}
+	if notMember.MemberNumber.Valid || notMember.ActivatedAt.Valid {
+		fmt.Printf("expect NULL fields, got %+v, %+v\n", notMember.MemberNumber, notMember.ActivatedAt)
+		return
+	}
+	// member M-001, activated at 2022-01-05T16:30:00+08:00
+	fmt.Println(describeMembership(member))
+	// not a member
+	fmt.Println(describeMembership(notMember))
+
+	data, err := json.Marshal(toMembershipDTO(notMember))
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if want := fmt.Sprintf(`{"ID":%d,"MemberNumber":null,"ActivatedAt":null}`, notMember.ID); string(data) != want {
+		fmt.Printf("expect %s, got %s\n", want, data)
+		return
+	}
+	data, err = json.Marshal(toMembershipDTO(member))
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if !strings.Contains(string(data), `"MemberNumber":"M-001"`) {
+		fmt.Printf("expect member number M-001, got %s\n", data)
+		return
+	}
+}
+
+func testUserInputOutput(gormDb *gorm.DB) {
+	if _, err := (UserInput{}).ToModel(); !errors.Is(err, ErrEmptyName) {
+		fmt.Printf("expect ErrEmptyName, got %v\n", err)
+		return
+	}
+	invalid := "not-an-email"
+	if _, err := (UserInput{Name: "sharpe-io", Email: &invalid}).ToModel(); !errors.Is(err, ErrInvalidEmail) {
+		fmt.Printf("expect ErrInvalidEmail, got %v\n", err)
+		return
+	}
+	if out := FromModel(nil); out != (UserOutput{}) {
+		fmt.Printf("expect zero output for nil user, got %+v\n", out)
+		return
+	}
+
+	// 只有 Name，空字符串的 Email 按没有设置处理
+	empty := ""
+	user, err := UserInput{Name: "sharpe-io-minimal", Email: &empty}.ToModel()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = gormDb.Create(user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	loaded := new(User)
+	if err = gormDb.First(loaded, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	data, err := json.Marshal(FromModel(loaded))
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for _, want := range []string{`"email":null`, `"member_number":null`, `"activated_at":null`, `"created_at":"`} {
+		if !strings.Contains(string(data), want) {
+			fmt.Printf("expect %s in %s\n", want, data)
+			return
+		}
+	}
+
+	// 所有字段都有值
+	email := fmt.Sprintf("sharpe-io-%d@example.com", time.Now().UnixNano())
+	memberNumber := "M-002"
+	activatedAt := time.Now().Truncate(time.Second)
+	in := UserInput{Name: "sharpe-io-full", Email: &email, Age: 33, MemberNumber: &memberNumber, ActivatedAt: &activatedAt}
+	if user, err = in.ToModel(); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = gormDb.Create(user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	loaded = new(User)
+	if err = gormDb.First(loaded, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	out := FromModel(loaded)
+	if out.Name != in.Name || out.Email == nil || *out.Email != email || out.Age != 33 ||
+		out.MemberNumber == nil || *out.MemberNumber != memberNumber || out.ActivatedAt == nil || !out.ActivatedAt.Equal(activatedAt) {
+		fmt.Printf("expect %+v to round-trip, got %+v\n", in, out)
+		return
+	}
+	if _, err = time.Parse(time.RFC3339, out.CreatedAt); err != nil {
+		fmt.Printf("expect created_at in RFC3339, got %q\n", out.CreatedAt)
+		return
+	}
+}
+
+func testUserString(gormDb *gorm.DB) {
+	email := "sharpe@example.com"
+	birthday := time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)
+	activatedAt := time.Date(2022, 1, 5, 8, 30, 0, 0, time.UTC)
+	full := User{
+		ID:           1,
+		Name:         "sharpe",
+		Email:        &email,
+		Age:          22,
+		Birthday:     &birthday,
+		MemberNumber: sql.NullString{String: "M-001", Valid: true},
+		ActivatedAt:  sql.NullTime{Time: activatedAt, Valid: true},
+		Status:       StatusActive,
+		CreatedAt:    activatedAt.Unix(),
+		UpdateOn:     activatedAt.Unix(),
+	}
+	want := "User{ID:1 Name:sharpe Email:sharpe@example.com Age:22 Birthday:2000-01-02T00:00:00Z MemberNumber:M-001 ActivatedAt:2022-01-05T08:30:00Z " +
+		"Status:" + StatusActive.String() + " CreatedAt:2022-01-05T08:30:00Z UpdateOn:2022-01-05T08:30:00Z}"
+	// %+v 也会调用 String
+	if got := fmt.Sprintf("%+v", full); got != want {
+		fmt.Printf("expect %s, got %s\n", want, got)
+		return
+	}
+
+	sparse := User{ID: 2, Name: "sparse", Status: StatusActive}
+	want = "User{ID:2 Name:sparse Email:<null> Age:0 Birthday:<null> MemberNumber:<null> ActivatedAt:<null> " +
+		"Status:" + StatusActive.String() + " CreatedAt:<null> UpdateOn:<null>}"
+	if got := sparse.String(); got != want {
+		fmt.Printf("expect %s, got %s\n", want, got)
+		return
+	}
+	fmt.Println(full)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+)
+
+var (
+	// ErrEmptyName 更新时 Name 被置为空字符串
+	ErrEmptyName = errors.New("user name can not be empty")
+	// ErrInvalidEmail Email 格式不合法
+	ErrInvalidEmail = errors.New("invalid email")
+	// ErrEmailExists Email 已被其他用户使用
+	ErrEmailExists = errors.New("email already exists")
+	// ErrConcurrentUpdate 记录在读取之后已被其他人修改
+	ErrConcurrentUpdate = errors.New("record was modified concurrently")
+	// ErrCannotDeleteAdmin 管理员不能被删除
+	ErrCannotDeleteAdmin = errors.New("can not delete admin user")
+	// ErrInsufficientBalance 余额不足
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	// ErrNotTestDatabase 清空表之前检查数据库名，名字中没有 test 时拒绝执行
+	ErrNotTestDatabase = errors.New("not a test database")
+	// ErrMigrationTimeout 迁移时 ctx 超时或被取消，而不是表结构有问题
+	ErrMigrationTimeout = errors.New("migration timed out")
+	// ErrUnknownScope 按名字查找的 scope 没有注册
+	ErrUnknownScope = errors.New("unknown scope")
+	// ErrInvalidSort 排序的列或方向不在允许的范围内
+	ErrInvalidSort = errors.New("invalid sort")
+	// ErrInvalidAgeRange 年龄范围的下限大于上限
+	ErrInvalidAgeRange = errors.New("invalid age range")
+	// ErrMissingColumns 模型的字段在表中没有对应的列，通常是忘记迁移了
+	ErrMissingColumns = errors.New("missing columns")
+
+	// ErrDuplicate 违反唯一约束
+	ErrDuplicate = errors.New("duplicate key")
+	// ErrForeignKey 违反外键约束
+	ErrForeignKey = errors.New("foreign key constraint fails")
+	// ErrDataTooLong 数据超过列的长度
+	ErrDataTooLong = errors.New("data too long")
+)
+
+// MySQL 错误码 https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+var mysqlErrors = map[uint16]error{
+	1062: ErrDuplicate,   // ER_DUP_ENTRY
+	1451: ErrForeignKey,  // ER_ROW_IS_REFERENCED_2 删除/更新被引用的父记录
+	1452: ErrForeignKey,  // ER_NO_REFERENCED_ROW_2 插入/更新时引用的父记录不存在
+	1406: ErrDataTooLong, // ER_DATA_TOO_LONG
+}
+
+// translateError 把驱动的错误翻译成包内定义的错误，调用方可以用 errors.Is 判断，无法识别的错误原样返回
+func translateError(err error) error {
+	var mysqlErr *gomysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return err
+	}
+	if target, ok := mysqlErrors[mysqlErr.Number]; ok {
+		return fmt.Errorf("%w: %s", target, mysqlErr.Message)
+	}
+	return err
+}
+
+// retryableMySQLErrors 事务被回滚、可以整体重试的错误
+var retryableMySQLErrors = map[uint16]bool{
+	1213: true, // ER_LOCK_DEADLOCK 死锁，InnoDB 回滚了其中一个事务
+	1205: true, // ER_LOCK_WAIT_TIMEOUT 等待行锁超时
+}
+
+// isRetryable 判断是否为死锁、锁等待超时这类重试可能成功的错误
+func isRetryable(err error) bool {
+	var mysqlErr *gomysql.MySQLError
+	return errors.As(err, &mysqlErr) && retryableMySQLErrors[mysqlErr.Number]
+}
+
+func testTranslateError() {
+	// 模拟驱动返回的错误，不需要连数据库
+	cases := []struct {
+		err    error
+		target error
+	}{
+		{&gomysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@gmail.com' for key 'idx_email_deleted'"}, ErrDuplicate},
+		{&gomysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails"}, ErrForeignKey},
+		{&gomysql.MySQLError{Number: 1406, Message: "Data too long for column 'name' at row 1"}, ErrDataTooLong},
+		{fmt.Errorf("create user: %w", &gomysql.MySQLError{Number: 1062, Message: "Duplicate entry"}), ErrDuplicate},
+	}
+	for _, c := range cases {
+		err := translateError(c.err)
+		if !errors.Is(err, c.target) {
+			fmt.Printf("translateError(%v) = %v, expect %v\n", c.err, err, c.target)
+			return
+		}
+		fmt.Println(err.Error())
+	}
+
+	// 无法识别的错误原样返回
+	unknown := &gomysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	if err := translateError(unknown); err != unknown {
+		fmt.Printf("translateError(%v) = %v, expect the original error\n", unknown, err)
+		return
+	}
+	if err := translateError(nil); err != nil {
+		fmt.Printf("translateError(nil) = %v, expect nil\n", err)
+	}
+}
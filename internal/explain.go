@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+)
+
+// explainSQL 在 DryRun 模式下执行 build，返回参数已内联的 SQL，不会真正访问数据库
+// build 需要调用 Find、First、Update 等终结方法，SQL 才会被生成
+// 内联后的 SQL 只用于查看，不要拿去执行
+func explainSQL(db *gorm.DB, build func(*gorm.DB) *gorm.DB) string {
+	tx := build(db.Session(&gorm.Session{DryRun: true}))
+	return tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
+}
+
+// toSQL 与 explainSQL 相同，但返回带占位符的 SQL 和参数，适合记录日志
+// db.ToSQL 返回的是参数已内联的 SQL，拿不到单独的参数
+func toSQL(db *gorm.DB, build func(*gorm.DB) *gorm.DB) (string, []interface{}) {
+	tx := build(db.Session(&gorm.Session{DryRun: true}))
+	return tx.Statement.SQL.String(), tx.Statement.Vars
+}
+
+// countQueries 统计 fn 执行的 SQL 条数，包括 Preload、关联的查询和写入，用于发现 N+1 查询
+// fn 拿到的是 countingSession 返回的 Session，db 上注册的回调和插件照常执行；注册计数回调失败时输出错误并返回 -1
+func countQueries(db *gorm.DB, fn func(*gorm.DB)) int {
+	session, counter, err := countingSession(db)
+	if err != nil {
+		fmt.Println(err.Error())
+		return -1
+	}
+	fn(session)
+	return counter.Count()
+}
+
+type statementCounterKey struct{}
+
+// statementCounter 按操作统计 SQL 条数，操作为 create、query、update、delete、row、raw，DryRun 的语句不计数
+type statementCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *statementCounter) add(operation string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[operation]++
+}
+
+// Count 返回 operations 的 SQL 条数之和，不传时返回所有操作的总数
+func (c *statementCounter) Count(operations ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(operations) == 0 {
+		total := 0
+		for _, count := range c.counts {
+			total += count
+		}
+		return total
+	}
+	total := 0
+	for _, operation := range operations {
+		total += c.counts[operation]
+	}
+	return total
+}
+
+func (c *statementCounter) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts = make(map[string]int)
+}
+
+var statementCounterMu sync.Mutex
+
+// countingSession 返回 db 的一个新 Session 和统计这个 Session 执行的 SQL 条数的 counter，连接池、回调和插件都与 db 共用
+// 回调注册在 Config 上，所有 Session 共用，所以计数回调在每个 Config 上只注册一次，通过 Statement.Context 中的 counter 区分是谁执行的；
+// 用 WithContext 换掉 ctx 的语句不会被统计，需要传入 session.Statement.Context 或由它派生的 ctx
+func countingSession(db *gorm.DB) (*gorm.DB, *statementCounter, error) {
+	if err := registerStatementCounter(db); err != nil {
+		return nil, nil, err
+	}
+	counter := &statementCounter{counts: make(map[string]int)}
+	ctx := context.WithValue(db.Statement.Context, statementCounterKey{}, counter)
+	return db.Session(&gorm.Session{NewDB: true, Context: ctx}), counter, nil
+}
+
+func registerStatementCounter(db *gorm.DB) error {
+	statementCounterMu.Lock()
+	defer statementCounterMu.Unlock()
+	callbacks := db.Callback()
+	if callbacks.Query().Get("statement_counter:query") != nil {
+		return nil
+	}
+
+	count := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			counter, ok := tx.Statement.Context.Value(statementCounterKey{}).(*statementCounter)
+			if ok && !tx.DryRun && tx.Statement.SQL.Len() > 0 {
+				counter.add(operation)
+			}
+		}
+	}
+	for _, err := range []error{
+		callbacks.Create().After("gorm:create").Register("statement_counter:create", count("create")),
+		callbacks.Query().After("gorm:query").Register("statement_counter:query", count("query")),
+		callbacks.Update().After("gorm:update").Register("statement_counter:update", count("update")),
+		callbacks.Delete().After("gorm:delete").Register("statement_counter:delete", count("delete")),
+		callbacks.Row().After("gorm:row").Register("statement_counter:row", count("row")),
+		callbacks.Raw().After("gorm:raw").Register("statement_counter:raw", count("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// standaloneDB 用 config 打开一个与 db 共用连接池的 *gorm.DB，它有自己的 Config，注册的回调和插件不会影响 db，
+// 用于需要注册自己的回调、插件或者修改 PrepareStmt 等配置的示例；db 上注册的回调和插件不会带过来
+// config 没有设置 NamingStrategy、Logger 时使用 db 的；连接池属于 db，不要关闭
+func standaloneDB(db *gorm.DB, config *gorm.Config) (*gorm.DB, error) {
+	dialector, ok := db.Dialector.(*mysql.Dialector)
+	if !ok {
+		return nil, fmt.Errorf("unsupported dialector %T", db.Dialector)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// 版本相关的配置已经在 db 初始化时确定，不需要再查询 VERSION()
+	mysqlConfig := *dialector.Config
+	mysqlConfig.Conn = sqlDB
+	mysqlConfig.SkipInitializeWithVersion = true
+	if config.NamingStrategy == nil {
+		config.NamingStrategy = db.NamingStrategy
+	}
+	if config.Logger == nil {
+		config.Logger = db.Logger
+	}
+	return gorm.Open(mysql.New(mysqlConfig), config)
+}
+
+func testExplainSQL(gormDb *gorm.DB) {
+	var users []User
+	result := gormDb.Where("name LIKE ?", "sharpe%").Order("id desc").Limit(3).Find(&users)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	// SELECT * FROM `t_users` WHERE name LIKE 'sharpe%' AND `t_users`.`is_deleted` = 0 ORDER BY id desc LIMIT 3
+	fmt.Println(explainSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("name LIKE ?", "sharpe%").Order("id desc").Limit(3).Find(&[]User{})
+	}))
+	fmt.Printf("users len = %d\n", len(users))
+
+	firstUser := new(User)
+	result = gormDb.First(firstUser)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	// SELECT * FROM `t_users` WHERE `t_users`.`is_deleted` = 0 ORDER BY `t_users`.`id` LIMIT 1
+	fmt.Println(explainSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.First(&User{})
+	}))
+	fmt.Printf("firstUser = %+v\n", firstUser)
+
+	// Struct 条件会忽略零值字段
+	// SELECT * FROM `t_users` WHERE `t_users`.`name` = 'sharpe-x' AND `t_users`.`is_deleted` = 0
+	fmt.Println(explainSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(&User{Name: "sharpe-x", Age: 0}).Find(&[]User{})
+	}))
+
+	// 更新语句同样可以查看
+	// UPDATE `t_users` SET `age`=age + 1,`update_on`=1641373000 WHERE id = 1 AND `t_users`.`is_deleted` = 0
+	fmt.Println(explainSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&User{}).Where("id = ?", 1).Update("age", gorm.Expr("age + ?", 1))
+	}))
+}
+
+func testToSQL(gormDb *gorm.DB) {
+	// Struct 条件忽略零值字段，Name 为空字符串不会出现在条件中
+	// SELECT * FROM `t_users` WHERE `t_users`.`age` = ? AND `t_users`.`is_deleted` = ? [20 0]
+	sql, vars := toSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(&User{Name: "", Age: 20}).Find(&[]User{})
+	})
+	if strings.Contains(sql, "`name`") || len(vars) != 2 || vars[0] != uint8(20) {
+		fmt.Printf("expect only age in struct condition, got %s %v\n", sql, vars)
+		return
+	}
+	fmt.Println(sql, vars)
+
+	// map 条件包含零值
+	// SELECT * FROM `t_users` WHERE `age` = ? AND `name` = ? AND `t_users`.`is_deleted` = ? [20  0]
+	sql, vars = toSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(map[string]interface{}{"name": "", "age": 20}).Find(&[]User{})
+	})
+	if !strings.Contains(sql, "`name` = ?") || len(vars) != 3 {
+		fmt.Printf("expect name and age in map condition, got %s %v\n", sql, vars)
+		return
+	}
+	fmt.Println(sql, vars)
+}
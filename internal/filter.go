@@ -0,0 +1,379 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// UserFilter 用户查询条件，零值字段不参与查询
+// 数值条件使用指针，用 nil 区分 "没有设置" 和 "设置为 0"
+type UserFilter struct {
+	NameLike  string
+	MinAge    *uint8
+	MaxAge    *uint8
+	CompanyID *uint
+}
+
+// Apply 按设置了的字段依次拼接 Where，可以直接作为 scope 使用 db.Scopes(filter.Apply)
+func (f UserFilter) Apply(db *gorm.DB) *gorm.DB {
+	if f.NameLike != "" {
+		db = db.Where("name LIKE ?", "%"+f.NameLike+"%")
+	}
+	if f.MinAge != nil {
+		db = db.Where("age >= ?", *f.MinAge)
+	}
+	if f.MaxAge != nil {
+		db = db.Where("age <= ?", *f.MaxAge)
+	}
+	if f.CompanyID != nil {
+		db = db.Where("company_id = ?", *f.CompanyID)
+	}
+	return db
+}
+
+// sortableColumns 允许排序的列，列名会直接出现在 SQL 中，不能使用用户传入的任意字符串
+var sortableColumns = map[string]bool{
+	"id":         true,
+	"name":       true,
+	"age":        true,
+	"created_at": true,
+}
+
+// applySort 按校验过的列和方向排序，dir 只能是 asc 或 desc
+// SELECT * FROM `t_users` ORDER BY `age` DESC
+func applySort(db *gorm.DB, field, dir string) (*gorm.DB, error) {
+	if !sortableColumns[field] {
+		return nil, fmt.Errorf("%w: column %q", ErrInvalidSort, field)
+	}
+	if dir != "asc" && dir != "desc" {
+		return nil, fmt.Errorf("%w: direction %q", ErrInvalidSort, dir)
+	}
+	return db.Clauses(clause.OrderBy{Columns: []clause.OrderByColumn{
+		{Column: clause.Column{Name: field}, Desc: dir == "desc"},
+	}}), nil
+}
+
+// findMinorsOrSeniors 未成年或 65 岁以上、名字以 prefix 开头的用户
+// 把 *gorm.DB 作为 Where 的参数时，其中的条件会作为一组用括号包起来：
+// SELECT * FROM `t_users` WHERE (age < 18 OR age > 65) AND name LIKE 's%' AND `t_users`.`is_deleted` = 0
+// 直接链式调用 Where("age < ?", 18).Or("age > ?", 65).Where(...) 不会加括号，AND 的优先级更高，结果是
+// age < 18 OR (age > 65 AND name LIKE 's%')
+// 分组的条件从 NewDB 的 Session 开始，gormDb 已经带有的条件不会被复制到括号中
+func findMinorsOrSeniors(gormDb *gorm.DB, prefix string) ([]User, error) {
+	var users []User
+	group := gormDb.Session(&gorm.Session{NewDB: true}).Where("age < ?", 18).Or("age > ?", 65)
+	err := gormDb.Where(group).Where("name LIKE ?", prefix+"%").Find(&users).Error
+	return users, err
+}
+
+// findAgeRange 年龄在 [min, max] 之间的用户，BETWEEN 包含两端，min 等于 max 时只匹配这一个年龄
+// Age 是 uint8，参数的范围是 0-255，不需要再检查上下界；min 大于 max 时 BETWEEN 什么也查不到，这里直接返回错误
+// SELECT * FROM `t_users` WHERE age BETWEEN 18 AND 30 AND `t_users`.`is_deleted` = 0
+func findAgeRange(db *gorm.DB, min, max uint8) ([]User, error) {
+	if min > max {
+		return nil, fmt.Errorf("%w: %d > %d", ErrInvalidAgeRange, min, max)
+	}
+	var users []User
+	err := db.Where("age BETWEEN ? AND ?", min, max).Find(&users).Error
+	return users, err
+}
+
+// likeEscaper 转义 LIKE 中的通配符，MySQL 默认的转义字符是 \，需要先转义它本身
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// searchByName 名字中包含 term 的用户，term 中的 % 和 _ 按字面匹配，不作为通配符
+// SELECT * FROM `t_users` WHERE name LIKE '%50\%%' AND `t_users`.`is_deleted` = 0
+func searchByName(db *gorm.DB, term string) ([]User, error) {
+	var users []User
+	err := db.Where("name LIKE ?", "%"+likeEscaper.Replace(term)+"%").Find(&users).Error
+	return users, err
+}
+
+// findNotNamed 名字不在 names 中的用户，Not 的用法与 Where 相同，条件整体取反
+// SELECT * FROM `t_users` WHERE NOT name IN ('a','b') AND `t_users`.`is_deleted` = 0
+// name 为 NULL 的行 NOT IN 的结果也是 NULL，不会返回；names 为空时生成 NOT name IN (NULL)，什么都查不到
+func findNotNamed(db *gorm.DB, names []string) ([]User, error) {
+	var users []User
+	err := db.Not("name IN ?", names).Find(&users).Error
+	return users, err
+}
+
+// listSorted 先按年龄从大到小；年龄相同时按激活时间从早到晚，没有激活(activated_at 为 NULL)的排在最后；最后按名字排序
+// 多次调用 Order 按调用顺序拼接
+// MySQL 升序时 NULL 排在最前面，也不支持 NULLS LAST，ISNULL(activated_at) 对 NULL 为 1，其余为 0，先按它排序就把 NULL 放到了最后；
+// PostgreSQL 可以直接写 activated_at ASC NULLS LAST
+// SELECT * FROM `t_users` WHERE `t_users`.`is_deleted` = 0 ORDER BY age DESC,ISNULL(activated_at),activated_at,name ASC
+func listSorted(db *gorm.DB) ([]User, error) {
+	var users []User
+	err := db.Order("age DESC").Order("ISNULL(activated_at)").Order("activated_at").Order("name ASC").Find(&users).Error
+	return users, err
+}
+
+func listUsers(gormDb *gorm.DB, filter UserFilter) ([]User, error) {
+	var users []User
+	err := gormDb.Scopes(filter.Apply).Find(&users).Error
+	return users, err
+}
+
+func testUserFilter(gormDb *gorm.DB) {
+	// Unscoped 去掉软删除的条件，只看 filter 生成的部分
+	// SELECT * FROM `t_users`
+	sql := explainSQL(gormDb.Unscoped(), func(tx *gorm.DB) *gorm.DB {
+		return tx.Scopes(UserFilter{}.Apply).Find(&[]User{})
+	})
+	if strings.Contains(sql, "WHERE") {
+		fmt.Printf("expect no WHERE for empty filter, got %s\n", sql)
+		return
+	}
+
+	// MinAge 为 0 也是有效条件
+	minAge := uint8(0)
+	filter := UserFilter{NameLike: "sharpe", MinAge: &minAge}
+	// SELECT * FROM `t_users` WHERE name LIKE '%sharpe%' AND age >= 0
+	sql = explainSQL(gormDb.Unscoped(), func(tx *gorm.DB) *gorm.DB {
+		return tx.Scopes(filter.Apply).Find(&[]User{})
+	})
+	for _, want := range []string{"name LIKE '%sharpe%'", "age >= 0"} {
+		if !strings.Contains(sql, want) {
+			fmt.Printf("expect %q in %s\n", want, sql)
+			return
+		}
+	}
+	for _, unwanted := range []string{"age <=", "company_id"} {
+		if strings.Contains(sql, unwanted) {
+			fmt.Printf("unexpected %q in %s\n", unwanted, sql)
+			return
+		}
+	}
+
+	users, err := listUsers(gormDb, filter)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("users len = %d\n", len(users))
+}
+
+func testApplySort(gormDb *gorm.DB) {
+	tx, err := applySort(gormDb.Unscoped().Session(&gorm.Session{DryRun: true}), "age", "desc")
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 列名经过 Quote
+	sql := tx.Find(&[]User{}).Statement.SQL.String()
+	if !strings.HasSuffix(sql, "ORDER BY `age` DESC") {
+		fmt.Printf("expect ORDER BY `age` DESC, got %s\n", sql)
+		return
+	}
+	fmt.Printf("sql = %s\n", sql)
+
+	if _, err := applySort(gormDb, "age; DROP TABLE t_users", "asc"); !errors.Is(err, ErrInvalidSort) {
+		fmt.Printf("expect ErrInvalidSort for column, got %v\n", err)
+		return
+	}
+	if _, err := applySort(gormDb, "age", "sideways"); !errors.Is(err, ErrInvalidSort) {
+		fmt.Printf("expect ErrInvalidSort for direction, got %v\n", err)
+		return
+	}
+}
+
+func testOrGroup(gormDb *gorm.DB) {
+	sql, vars := toSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(gormDb.Where("age < ?", 18).Or("age > ?", 65)).Where("name LIKE ?", "s%").Find(&[]User{})
+	})
+	if !strings.Contains(sql, "WHERE (age < ? OR age > ?) AND name LIKE ?") {
+		fmt.Printf("expect OR conditions to be grouped, got %s\n", sql)
+		return
+	}
+	fmt.Println(sql, vars)
+
+	// db 已经带有条件时，从 db 开始的分组会把这些条件一起复制到括号中
+	// SELECT * FROM `t_users` WHERE id > ? AND (id > ? AND age < ? OR age > ?) AND `t_users`.`is_deleted` = ?
+	scoped := session(gormDb.Where("id > ?", 0))
+	sql, _ = toSQL(scoped, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(scoped.Where("age < ?", 18).Or("age > ?", 65)).Find(&[]User{})
+	})
+	if !strings.Contains(sql, "(id > ? AND age < ? OR age > ?)") {
+		fmt.Printf("expect the outer condition to be copied into the group, got %s\n", sql)
+		return
+	}
+	// SELECT * FROM `t_users` WHERE id > ? AND (age < ? OR age > ?) AND `t_users`.`is_deleted` = ?
+	sql, _ = toSQL(scoped, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(scoped.Session(&gorm.Session{NewDB: true}).Where("age < ?", 18).Or("age > ?", 65)).Find(&[]User{})
+	})
+	if !strings.Contains(sql, "WHERE id > ? AND (age < ? OR age > ?)") {
+		fmt.Printf("expect only the OR conditions in the group, got %s\n", sql)
+		return
+	}
+
+	users, err := findMinorsOrSeniors(gormDb, "sharpe")
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for _, user := range users {
+		if user.Age >= 18 && user.Age <= 65 {
+			fmt.Printf("unexpected user %+v\n", user)
+			return
+		}
+	}
+	fmt.Printf("users len = %d\n", len(users))
+}
+
+func testFindAgeRange(gormDb *gorm.DB) {
+	users := []User{{Name: "sharpe-range-1", Age: 17}, {Name: "sharpe-range-2", Age: 18}, {Name: "sharpe-range-3", Age: 30}, {Name: "sharpe-range-4", Age: 31}}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	ids := []uint{users[0].ID, users[1].ID, users[2].ID, users[3].ID}
+
+	// 包含两端
+	inRange, err := findAgeRange(gormDb.Where("id IN ?", ids), 18, 30)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(inRange) != 2 || inRange[0].Age != 18 || inRange[1].Age != 30 {
+		fmt.Printf("expect ages 18 and 30, got %v\n", inRange)
+		return
+	}
+
+	// 上下限相同
+	single, err := findAgeRange(gormDb.Where("id IN ?", ids), 31, 31)
+	if err != nil || len(single) != 1 || single[0].ID != users[3].ID {
+		fmt.Printf("expect only user %d, got %v, %v\n", users[3].ID, single, err)
+		return
+	}
+
+	if _, err = findAgeRange(gormDb, 30, 18); !errors.Is(err, ErrInvalidAgeRange) {
+		fmt.Printf("expect ErrInvalidAgeRange, got %v\n", err)
+		return
+	}
+}
+
+func testSearchByName(gormDb *gorm.DB) {
+	users := []User{{Name: "sharpe-search-50%-off"}, {Name: "sharpe-search-500-off"}, {Name: "sharpe-search-a_b"}, {Name: "sharpe-search-axb"}}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	ids := []uint{users[0].ID, users[1].ID, users[2].ID, users[3].ID}
+
+	// 不转义时 50% 会匹配 500
+	cases := map[string]uint{"50%": users[0].ID, "a_b": users[2].ID}
+	for term, want := range cases {
+		found, err := searchByName(gormDb.Where("id IN ?", ids), term)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		if len(found) != 1 || found[0].ID != want {
+			fmt.Printf("expect only user %d for %q, got %v\n", want, term, found)
+			return
+		}
+	}
+}
+
+func testNot(gormDb *gorm.DB) {
+	users := []User{{Name: "sharpe-not-1", Age: 20}, {Name: "sharpe-not-2", Age: 30}, {Name: "sharpe-not-3", Age: 40}}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	ids := []uint{users[0].ID, users[1].ID, users[2].ID}
+
+	sql := explainSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Not("name IN ?", []string{"sharpe-not-1"}).Find(&[]User{})
+	})
+	if !strings.Contains(sql, "NOT name IN ('sharpe-not-1')") {
+		fmt.Printf("expect NOT name IN, got %s\n", sql)
+		return
+	}
+	found, err := findNotNamed(gormDb.Where("id IN ?", ids), []string{"sharpe-not-1", "sharpe-not-2"})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(found) != 1 || found[0].ID != users[2].ID {
+		fmt.Printf("expect only user %d, got %v\n", users[2].ID, found)
+		return
+	}
+
+	// map 的值为切片时生成 NOT IN
+	// SELECT * FROM `t_users` WHERE `name` NOT IN ('sharpe-not-1','sharpe-not-3') AND `t_users`.`is_deleted` = 0
+	// 注意 map 或结构体中有多个字段时，每个条件分别取反再用 AND 连接：(`age` <> 20 AND `name` <> 'a')，而不是 NOT (age = 20 AND name = 'a')
+	found = nil
+	err = gormDb.Where("id IN ?", ids).Not(map[string]interface{}{"name": []string{"sharpe-not-1", "sharpe-not-3"}}).Find(&found).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(found) != 1 || found[0].ID != users[1].ID {
+		fmt.Printf("expect only user %d, got %v\n", users[1].ID, found)
+		return
+	}
+
+	// 结构体只使用非零值字段
+	// SELECT * FROM `t_users` WHERE id IN (1,2,3) AND `t_users`.`age` <> 20 AND `t_users`.`is_deleted` = 0
+	sql = explainSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Not(User{Age: 20}).Find(&[]User{})
+	})
+	if !strings.Contains(sql, "`t_users`.`age` <> 20") {
+		fmt.Printf("expect `t_users`.`age` <> 20, got %s\n", sql)
+		return
+	}
+	found = nil
+	if err = gormDb.Where("id IN ?", ids).Not(User{Age: 20}).Find(&found).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(found) != 2 {
+		fmt.Printf("expect 2 users not aged 20, got %v\n", found)
+		return
+	}
+}
+
+func testListSorted(gormDb *gorm.DB) {
+	now := time.Now().Truncate(time.Second)
+	users := []User{
+		{Name: "sharpe-sorted-a", Age: 30},
+		{Name: "sharpe-sorted-b", Age: 30, ActivatedAt: sql.NullTime{Time: now, Valid: true}},
+		{Name: "sharpe-sorted-c", Age: 40, ActivatedAt: sql.NullTime{Time: now.Add(-time.Hour), Valid: true}},
+		{Name: "sharpe-sorted-d", Age: 20, ActivatedAt: sql.NullTime{Time: now.Add(-2 * time.Hour), Valid: true}},
+		{Name: "sharpe-sorted-e", Age: 30, ActivatedAt: sql.NullTime{Time: now.Add(-3 * time.Hour), Valid: true}},
+		{Name: "sharpe-sorted-f", Age: 30},
+	}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	ids := make([]uint, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	names := func(users []User) string {
+		parts := make([]string, 0, len(users))
+		for _, user := range users {
+			parts = append(parts, strings.TrimPrefix(user.Name, "sharpe-sorted-"))
+		}
+		return strings.Join(parts, ",")
+	}
+
+	sorted, err := listSorted(gormDb.Where("id IN ?", ids))
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 30 岁的四个人中激活早的 e 在前，没有激活的 a、f 排在最后，虽然 a 的名字排在最前面
+	if got := names(sorted); got != "c,e,b,a,f,d" {
+		fmt.Printf("expect c,e,b,a,f,d, got %s\n", got)
+		return
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// datatypes.JSON https://github.com/go-gorm/datatypes
+// 写入时 nil 和空切片都会存为 NULL，读出时 NULL 会被扫描成 JSON("null")；空对象 {} 则原样存为 '{}'
+func testJSONSettings(gormDb *gorm.DB) {
+	user := User{
+		Name:     "sharpe-json",
+		Settings: datatypes.JSON(`{"theme": "dark", "notify": {"email": true}}`),
+	}
+	result := gormDb.Create(&user)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	jsonUser := new(User)
+	result = gormDb.First(jsonUser, user.ID)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	fmt.Printf("settings = %s\n", jsonUser.Settings)
+
+	// 按 JSON 中的 key 查询
+	// SELECT * FROM `t_users` WHERE JSON_EXTRACT(`settings`,'$.theme') = "dark" AND `t_users`.`is_deleted` = 0
+	var darkUsers []User
+	result = gormDb.Where(datatypes.JSONQuery("settings").Equals("dark", "theme")).Find(&darkUsers)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	fmt.Printf("darkUsers len = %d\n", len(darkUsers))
+
+	// 嵌套的 key
+	// SELECT * FROM `t_users` WHERE JSON_EXTRACT(`settings`,'$.notify.email') = true AND `t_users`.`is_deleted` = 0
+	var notifyUsers []User
+	result = gormDb.Where(datatypes.JSONQuery("settings").Equals(true, "notify", "email")).Find(&notifyUsers)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	fmt.Printf("notifyUsers len = %d\n", len(notifyUsers))
+
+	// HasKey
+	// SELECT * FROM `t_users` WHERE JSON_EXTRACT(`settings`,'$.notify') IS NOT NULL AND `t_users`.`is_deleted` = 0
+	var hasKeyUsers []User
+	result = gormDb.Where(datatypes.JSONQuery("settings").HasKey("notify")).Find(&hasKeyUsers)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	fmt.Printf("hasKeyUsers len = %d\n", len(hasKeyUsers))
+
+	// nil 与空对象
+	nilUser := User{Name: "sharpe-json-nil"}
+	emptyUser := User{Name: "sharpe-json-empty", Settings: datatypes.JSON(`{}`)}
+	result = gormDb.Create([]*User{&nilUser, &emptyUser})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	var nullCount, emptyCount int64
+	result = gormDb.Model(&User{}).Where("id = ? AND settings IS NULL", nilUser.ID).Count(&nullCount)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	result = gormDb.Model(&User{}).Where("id = ? AND settings = JSON_OBJECT()", emptyUser.ID).Count(&emptyCount)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	// nullCount = 1, emptyCount = 1
+	fmt.Printf("nullCount = %d, emptyCount = %d\n", nullCount, emptyCount)
+
+	result = gormDb.First(&nilUser, nilUser.ID)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	// nilUser.Settings = null
+	fmt.Printf("nilUser.Settings = %s\n", nilUser.Settings)
+}
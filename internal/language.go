@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Language 语言，与 User 是多对多的关系，连接表为 t_user_languages
+type Language struct {
+	ID   uint
+	Code string `gorm:"size:8;uniqueIndex"`
+	Name string `gorm:"size:64"`
+}
+
+// findUsersWithLanguages 预加载用户的语言，只加载 code 在 codes 中的语言，其余的不会出现在 User.Languages 中
+// SELECT * FROM `t_users` WHERE id IN (1) AND `t_users`.`is_deleted` = 0
+// SELECT * FROM `t_user_languages` WHERE `t_user_languages`.`user_id` = 1
+// SELECT * FROM `t_languages` WHERE `t_languages`.`id` IN (1,2,3) AND code IN ('en','fr')
+func findUsersWithLanguages(gormDb *gorm.DB, ids []uint, codes []string) ([]User, error) {
+	var users []User
+	err := gormDb.Preload("Languages", "code IN ?", codes).Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
+
+// findUserWithAssociations clause.Associations 预加载所有直接关联：Company、Profile、Languages，不会继续加载关联的关联
+func findUserWithAssociations(gormDb *gorm.DB, id uint) (*User, error) {
+	user := new(User)
+	if err := gormDb.Preload(clause.Associations).First(user, id).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// findUserWithSortedLanguages Preload 的参数是函数时可以修改预加载的查询，这里按 code 排序
+// 表名带有 t_ 前缀，ORDER BY 中需要写 t_languages
+// SELECT * FROM `t_languages` WHERE `t_languages`.`id` IN (3,1,2) ORDER BY t_languages.code ASC
+func findUserWithSortedLanguages(gormDb *gorm.DB, id uint) (*User, error) {
+	user := new(User)
+	err := gormDb.Preload("Languages", func(db *gorm.DB) *gorm.DB {
+		return db.Order("t_languages.code ASC")
+	}).First(user, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func testPreloadLanguages(gormDb *gorm.DB) {
+	// 语言按 code 唯一，多次运行时复用已有的记录
+	languages := []Language{{Code: "en", Name: "English"}, {Code: "fr", Name: "French"}, {Code: "de", Name: "German"}}
+	for i := range languages {
+		if err := gormDb.Where(Language{Code: languages[i].Code}).FirstOrCreate(&languages[i]).Error; err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
+	// 语言已经有主键，创建 User 时只会写入连接表
+	user := User{Name: "sharpe-languages", Languages: languages, Profile: &Profile{Bio: "polyglot"}}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	users, err := findUsersWithLanguages(gormDb, []uint{user.ID}, []string{"en", "fr"})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(users) != 1 || len(users[0].Languages) != 2 {
+		fmt.Printf("expect 1 user with 2 languages, got %+v\n", users)
+		return
+	}
+	for _, language := range users[0].Languages {
+		if language.Code != "en" && language.Code != "fr" {
+			fmt.Printf("unexpected language %s\n", language.Code)
+			return
+		}
+	}
+
+	loaded, err := findUserWithAssociations(gormDb, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(loaded.Languages) != 3 || loaded.Profile == nil || loaded.Profile.Bio != "polyglot" {
+		fmt.Printf("expect all languages and profile to be preloaded, got %+v\n", loaded)
+		return
+	}
+}
+
+func testPreloadSortedLanguages(gormDb *gorm.DB) {
+	// 以 code 倒序关联
+	languages := []Language{{Code: "fr", Name: "French"}, {Code: "en", Name: "English"}, {Code: "de", Name: "German"}}
+	for i := range languages {
+		if err := gormDb.Where(Language{Code: languages[i].Code}).FirstOrCreate(&languages[i]).Error; err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+	user := User{Name: "sharpe-sorted-languages", Languages: languages}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	loaded, err := findUserWithSortedLanguages(gormDb, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	codes := make([]string, 0, len(loaded.Languages))
+	for _, language := range loaded.Languages {
+		codes = append(codes, language.Code)
+	}
+	if fmt.Sprint(codes) != "[de en fr]" {
+		fmt.Printf("expect languages sorted by code, got %v\n", codes)
+		return
+	}
+}
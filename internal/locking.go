@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync"
+	"time"
+)
+
+// 悲观锁 https://gorm.io/zh_CN/docs/advanced_query.html#Locking
+// 行锁只在事务中生效，事务提交或回滚时释放；在事务外执行 FOR UPDATE 语句结束锁就释放了，没有意义
+// 只有 InnoDB 支持行锁，MyISAM 只有表锁；条件没有命中索引时 InnoDB 会锁住更多的行
+
+// withRetry 执行 fn，遇到死锁或锁等待超时时重试，最多执行 attempts 次，每次重试前的等待时间翻倍
+// 重试的是整个事务，fn 中不能有事务之外的副作用；其他错误直接返回，重试次数用完时返回最后一次的错误
+func withRetry(db *gorm.DB, attempts int, fn func(*gorm.DB) error) error {
+	backoff := 10 * time.Millisecond
+	for i := 1; ; i++ {
+		err := fn(db)
+		if err == nil || !isRetryable(err) || i >= attempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// growUpWithLock 锁住用户记录后再修改年龄，并发执行时后来的事务会等待前一个事务提交，死锁或等待超时时重试
+func growUpWithLock(gormDb *gorm.DB, id uint) error {
+	return withRetry(gormDb, 3, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			user := new(User)
+			// SELECT * FROM `t_users` WHERE `t_users`.`id` = 1 AND `t_users`.`is_deleted` = 0 ORDER BY `t_users`.`id` LIMIT 1 FOR UPDATE
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(user, id).Error; err != nil {
+				return err
+			}
+			return tx.Model(user).Update("age", user.Age+1).Error
+		})
+	})
+}
+
+// Counter 计数器，Name 唯一
+type Counter struct {
+	ID    uint
+	Name  string `gorm:"size:64;uniqueIndex"`
+	Value int64
+}
+
+// incrementCounter 在事务中锁住计数器后在 Go 中加一再保存，返回加一之后的值，计数器不存在时先创建
+// INSERT INTO `t_counters` (`name`,`value`) VALUES ('visits',0) ON DUPLICATE KEY UPDATE `id`=`id`
+// SELECT * FROM `t_counters` WHERE name = 'visits' ORDER BY `t_counters`.`id` LIMIT 1 FOR UPDATE
+// UPDATE `t_counters` SET `value`=1 WHERE `id` = 1
+// 与 incrementAge 的 gorm.Expr 写法相比多了一次查询并且要持有行锁到事务提交，
+// 只有新值需要在 Go 中计算(比如有上限、要根据旧值做判断)时才需要这样写，单纯加一用 gorm.Expr 就够了
+func incrementCounter(db *gorm.DB, name string) (int64, error) {
+	var value int64
+	err := withRetry(db, 3, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			// 并发创建同一个计数器时只有一条能插入成功，其余的忽略唯一键冲突
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Counter{Name: name}).Error; err != nil {
+				return err
+			}
+			counter := new(Counter)
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ?", name).First(counter).Error; err != nil {
+				return err
+			}
+			counter.Value++
+			if err := tx.Model(counter).Update("value", counter.Value).Error; err != nil {
+				return err
+			}
+			value = counter.Value
+			return nil
+		})
+	})
+	return value, err
+}
+
+func testIncrementCounter(gormDb *gorm.DB) {
+	name := fmt.Sprintf("sharpe-counter-%d", time.Now().UnixNano())
+	const workers, times = 5, 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < times; j++ {
+				if _, err := incrementCounter(gormDb, name); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		fmt.Println(err.Error())
+		return
+	}
+
+	counter := new(Counter)
+	if err := gormDb.Where("name = ?", name).First(counter).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if counter.Value != workers*times {
+		fmt.Printf("expect value = %d, got %d\n", workers*times, counter.Value)
+		return
+	}
+}
+
+// shareLockAge 共享锁，其他事务可以读但不能修改，直到当前事务结束
+func shareLockAge(gormDb *gorm.DB, id uint) (age uint8, err error) {
+	err = gormDb.Transaction(func(tx *gorm.DB) error {
+		user := new(User)
+		// SELECT * FROM `t_users` WHERE `t_users`.`id` = 1 AND `t_users`.`is_deleted` = 0 ORDER BY `t_users`.`id` LIMIT 1 FOR SHARE
+		// MySQL 5.7 及以前使用 LOCK IN SHARE MODE
+		if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).First(user, id).Error; err != nil {
+			return err
+		}
+		age = user.Age
+		return nil
+	})
+	return
+}
+
+func testLocking(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-lock", Age: 18}
+	result := gormDb.Create(&user)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	if err := growUpWithLock(gormDb, user.ID); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	age, err := shareLockAge(gormDb, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// age = 19
+	fmt.Printf("age = %d\n", age)
+
+	// 锁超时或者锁不到时不等待
+	// SELECT ... FOR UPDATE NOWAIT
+	// db.Clauses(clause.Locking{Strength: "UPDATE", Options: "NOWAIT"}).Find(&users)
+}
+
+// 乐观锁：不加锁，更新时带上读到的版本号，版本号变了说明期间有人修改过，RowsAffected 为 0
+// renameWithVersion 修改 u 的名字，u 需要是查出来的记录
+func renameWithVersion(gormDb *gorm.DB, u *User, newName string) error {
+	// UPDATE `t_users` SET `name`='new',`version`=2,`update_on`=1641373000 WHERE version = 1 AND `t_users`.`is_deleted` = 0 AND `id` = 1
+	result := gormDb.Model(u).Where("version = ?", u.Version).Updates(map[string]interface{}{
+		"name":    newName,
+		"version": u.Version + 1,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConcurrentUpdate
+	}
+	u.Name = newName
+	u.Version++
+	return nil
+}
+
+func testOptimisticLock(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-version"}
+	result := gormDb.Create(&user)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	// 模拟两个并发的更新者读到了同一个版本
+	first, second := new(User), new(User)
+	if err := gormDb.First(first, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.First(second, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if err := renameWithVersion(gormDb, first, "sharpe-version-first"); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 第二个更新者的版本号已经过期
+	err := renameWithVersion(gormDb, second, "sharpe-version-second")
+	if !errors.Is(err, ErrConcurrentUpdate) {
+		fmt.Printf("expect ErrConcurrentUpdate, got %v\n", err)
+		return
+	}
+
+	// 重新读取后可以更新
+	if err = gormDb.First(second, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = renameWithVersion(gormDb, second, "sharpe-version-second"); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// second = {Name:sharpe-version-second Version:3}
+	fmt.Printf("second = {Name:%s Version:%d}\n", second.Name, second.Version)
+}
+
+// incrementAge 原子地增加年龄，加法在数据库中完成，不需要先查询
+// UPDATE `t_users` SET `age`=age + 1,`update_on`=1641373000 WHERE id = 1 AND `t_users`.`is_deleted` = 0
+// 先 First 再 Save 的写法中，两个并发请求可能读到同一个旧值，后提交的会覆盖先提交的(丢失更新)；
+// gorm.Expr 让读和写在同一条 UPDATE 语句中完成，InnoDB 会对这一行加锁，不会丢失更新
+func incrementAge(gormDb *gorm.DB, id uint, by int) error {
+	return gormDb.Model(&User{}).Where("id = ?", id).Update("age", gorm.Expr("age + ?", by)).Error
+}
+
+func testIncrementAge(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-increment", Age: 18}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- incrementAge(gormDb, user.ID, 1)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
+	incremented := new(User)
+	if err := gormDb.First(incremented, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if incremented.Age != 20 {
+		fmt.Printf("expect age = 20, got %d\n", incremented.Age)
+		return
+	}
+}
+
+func testWithRetry(gormDb *gorm.DB) {
+	deadlock := &gomysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"}
+
+	// 第一次返回死锁，第二次成功
+	calls := 0
+	err := withRetry(gormDb, 3, func(db *gorm.DB) error {
+		calls++
+		if calls == 1 {
+			return fmt.Errorf("grow up: %w", deadlock)
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		fmt.Printf("expect success on the second attempt, got %d calls, %v\n", calls, err)
+		return
+	}
+
+	// 不可重试的错误只执行一次
+	calls = 0
+	err = withRetry(gormDb, 3, func(db *gorm.DB) error {
+		calls++
+		return gorm.ErrRecordNotFound
+	})
+	if !errors.Is(err, gorm.ErrRecordNotFound) || calls != 1 {
+		fmt.Printf("expect no retry, got %d calls, %v\n", calls, err)
+		return
+	}
+
+	// 次数用完时返回最后一次的错误
+	calls = 0
+	err = withRetry(gormDb, 3, func(db *gorm.DB) error {
+		calls++
+		return deadlock
+	})
+	if !errors.Is(err, deadlock) || calls != 3 {
+		fmt.Printf("expect 3 attempts, got %d calls, %v\n", calls, err)
+		return
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"log"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID 把请求 ID 放到 ctx 中，配合 db.WithContext 使用，日志中的 SQL 会带上这个 ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestIDFrom(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok && id != "" {
+		return id
+	}
+	return "-"
+}
+
+// requestIDLogger 实现 logger.Interface，每行日志以 [request_id=xxx] 开头，ctx 中没有请求 ID 时为 [request_id=-]
+// 级别的含义与 GORM 默认的 logger 相同：Error 只输出出错的 SQL，Warn 还会输出慢 SQL，Info 输出所有 SQL
+type requestIDLogger struct {
+	writer        logger.Writer
+	level         logger.LogLevel
+	slowThreshold time.Duration
+}
+
+func newRequestIDLogger(writer logger.Writer, level logger.LogLevel) logger.Interface {
+	return &requestIDLogger{writer: writer, level: level, slowThreshold: 200 * time.Millisecond}
+}
+
+func (l *requestIDLogger) LogMode(level logger.LogLevel) logger.Interface {
+	newLogger := *l
+	newLogger.level = level
+	return &newLogger
+}
+
+func (l *requestIDLogger) printf(ctx context.Context, format string, args ...interface{}) {
+	l.writer.Printf("[request_id=%s] "+format, append([]interface{}{requestIDFrom(ctx)}, args...)...)
+}
+
+func (l *requestIDLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Info {
+		l.printf(ctx, msg, args...)
+	}
+}
+
+func (l *requestIDLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Warn {
+		l.printf(ctx, msg, args...)
+	}
+}
+
+func (l *requestIDLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Error {
+		l.printf(ctx, msg, args...)
+	}
+}
+
+// Trace 每条 SQL 执行后调用，fc 返回内联了参数的 SQL 和影响的行数
+func (l *requestIDLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	switch {
+	// 查询不到记录是正常的业务结果，不作为错误输出
+	case err != nil && l.level >= logger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		l.printf(ctx, "%s [%.3fms] [rows:%d] %s", err, float64(elapsed.Nanoseconds())/1e6, rows, sql)
+	case elapsed > l.slowThreshold && l.level >= logger.Warn:
+		sql, rows := fc()
+		l.printf(ctx, "SLOW SQL >= %v [%.3fms] [rows:%d] %s", l.slowThreshold, float64(elapsed.Nanoseconds())/1e6, rows, sql)
+	case l.level >= logger.Info:
+		sql, rows := fc()
+		l.printf(ctx, "[%.3fms] [rows:%d] %s", float64(elapsed.Nanoseconds())/1e6, rows, sql)
+	}
+}
+
+// callerOutsideGorm 从调用栈中找到第一个不在 GORM 及其插件中的调用方，返回 file:line
+func callerOutsideGorm() string {
+	for i := 2; ; i++ {
+		_, file, line, ok := runtime.Caller(i)
+		if !ok {
+			return "unknown"
+		}
+		if !strings.Contains(file, "gorm.io/") {
+			return fmt.Sprintf("%s:%d", file, line)
+		}
+	}
+}
+
+// registerSlowQueryTracer 查询超过 threshold 时输出 SQL 和发起查询的代码位置，Find、First 等走 Query，Raw().Scan、Rows 走 Row
+// logger 的慢查询日志只有 GORM 内部的位置，这里跳过 GORM 的栈帧，定位到业务代码
+// SLOW QUERY >= 10ms [52.310ms] /root/module/internal/logger.go:150 SELECT SLEEP(0.05)
+func registerSlowQueryTracer(db *gorm.DB, threshold time.Duration) error {
+	const startKey = "slow_query:start"
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(startKey, time.Now())
+	}
+	after := func(tx *gorm.DB) {
+		start, ok := tx.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		if elapsed := time.Since(start.(time.Time)); elapsed >= threshold {
+			sql := tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...)
+			tx.Logger.Warn(tx.Statement.Context, "SLOW QUERY >= %v [%.3fms] %s %s", threshold, float64(elapsed.Nanoseconds())/1e6, callerOutsideGorm(), sql)
+		}
+	}
+
+	if err := db.Callback().Query().Before("gorm:query").Register("slow_query:before_query", before); err != nil {
+		return err
+	}
+	if err := db.Callback().Query().After("gorm:query").Register("slow_query:after_query", after); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("slow_query:before_row", before); err != nil {
+		return err
+	}
+	return db.Callback().Row().After("gorm:row").Register("slow_query:after_row", after)
+}
+
+func testRequestIDLogger(gormDb *gorm.DB) {
+	var buf bytes.Buffer
+	tx := gormDb.Session(&gorm.Session{Logger: newRequestIDLogger(log.New(&buf, "", 0), logger.Info)})
+
+	ctx := WithRequestID(context.Background(), "req-42")
+	if err := tx.WithContext(ctx).Where("name LIKE ?", "sharpe%").Find(&[]User{}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// [request_id=req-42] [0.512ms] [rows:3] SELECT * FROM `t_users` WHERE name LIKE 'sharpe%' AND `t_users`.`is_deleted` = 0
+	out := buf.String()
+	if !strings.Contains(out, "[request_id=req-42]") || !strings.Contains(out, "SELECT * FROM `t_users`") {
+		fmt.Printf("expect request id and sql in log, got %q\n", out)
+		return
+	}
+	fmt.Print(out)
+
+	// 没有请求 ID 时使用 -
+	buf.Reset()
+	if err := tx.Find(&[]User{}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if !strings.HasPrefix(buf.String(), "[request_id=-]") {
+		fmt.Printf("expect [request_id=-], got %q\n", buf.String())
+		return
+	}
+}
+
+func testSlowQueryTracer(gormDb *gorm.DB) {
+	var buf bytes.Buffer
+	// 回调注册在 Config 上，使用一个单独的 Config，logger 的慢查询阈值为 200ms，不会重复输出
+	traceDb, err := standaloneDB(gormDb, &gorm.Config{
+		Logger: newRequestIDLogger(log.New(&buf, "", 0), logger.Warn),
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = registerSlowQueryTracer(traceDb, 10*time.Millisecond); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// 没有超过阈值的查询不输出
+	if err = traceDb.Take(&User{}).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Println(err.Error())
+		return
+	}
+	if buf.Len() != 0 {
+		fmt.Printf("expect no log for a fast query, got %q\n", buf.String())
+		return
+	}
+
+	var slept int
+	if err = traceDb.Raw("SELECT SLEEP(?)", 0.05).Scan(&slept).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	out := buf.String()
+	if !strings.Contains(out, "SLOW QUERY") || !strings.Contains(out, "logger.go:") || !strings.Contains(out, "SELECT SLEEP(0.05)") {
+		fmt.Printf("expect slow query with caller logger.go, got %q\n", out)
+		return
+	}
+	fmt.Print(out)
+}
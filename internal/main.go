@@ -1,15 +1,28 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"flag"
 	"fmt"
+	"github.com/shopspring/decimal"
 	"github.com/spf13/viper"
+	"gorm.io/datatypes"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 	"gorm.io/plugin/soft_delete"
 	"log"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,9 +40,12 @@ func init() {
 // GORM 倾向于约定(https://gorm.io/zh_CN/docs/conventions.html)，而不是配置。默认情况下，GORM 使用 ID 作为主键，
 // 使用结构体名的 蛇形复数 作为表名，字段名的 蛇形 作为列名，并使用 CreatedAt、UpdatedAt 字段追踪创建、更新时间
 type User struct {
-	ID           uint
-	Name         string
-	Email        *string `gorm:"default:default@gmail.com"`
+	ID uint
+	// 与 CompanyID 组成联合唯一索引 idx_company_name，同一个公司下的用户名不能重复
+	Name string `gorm:"size:64;uniqueIndex:idx_company_name"`
+	// 与 IsDeleted 组成联合唯一索引 idx_email_deleted，MySQL 的 text 类型不能直接建索引，需要指定长度
+	// 唯一索引允许多个 NULL，所以不再设置默认值，Email 为 nil 时写入 NULL
+	Email        *string `gorm:"size:255;uniqueIndex:idx_email_deleted"`
 	Age          uint8
 	Birthday     *time.Time
 	MemberNumber sql.NullString
@@ -40,11 +56,43 @@ type User struct {
 	CreatedAt int64 `gorm:"autoCreateTime"`
 	// UpdatedAt time.Time
 	// 要使用不同名称的字段，您可以配置 autoCreateTime、autoUpdateTime 标签
-	UpdateOn  int64                 `gorm:"autoUpdateTime"`
-	IsDeleted soft_delete.DeletedAt `gorm:"softDelete:flag default:0"`
+	UpdateOn int64 `gorm:"autoUpdateTime"`
+	// 未删除时为 0，软删除后为删除时间，所以 (email, is_deleted) 唯一时，软删除的记录不再占用 Email，
+	// 而两条未删除的记录仍然冲突；同一秒内删除两个相同 Email 的记录时会冲突
+	IsDeleted soft_delete.DeletedAt `gorm:"softDelete:flag default:0;uniqueIndex:idx_email_deleted"`
+	// 属于 Company，CompanyID 为 NULL 时不参与联合唯一索引的比较，没有公司的用户可以重名
+	CompanyID *uint `gorm:"uniqueIndex:idx_company_name"`
+	Company   *Company
+	// 灵活的属性字段，MySQL 中为 JSON 类型
+	Settings datatypes.JSON
+	// 自定义类型，零值时使用默认值 StatusActive
+	Status Status `gorm:"default:1"`
+	// 乐观锁版本号
+	Version int `gorm:"default:1"`
+	// 拥有一个 Profile，外键为 Profile.UserID
+	Profile *Profile
+	// 多对多，连接表 t_user_languages 由 AutoMigrate 创建
+	Languages []Language `gorm:"many2many:user_languages"`
+	// 操作人，由 registerAuditCallbacks 从 ctx 中读取并填充
+	CreatedBy string `gorm:"size:64"`
+	UpdatedBy string `gorm:"size:64"`
+	// 最后一次写入这条记录的请求的关联 ID，由 registerTraceIDCallbacks 填充
+	TraceID string `gorm:"size:36"`
+	// 加密保存的 Email，用于只展示不查询的场景；Email 需要唯一索引和查询，仍然保存明文
+	// 32 字节的密钥加密 255 个字符的 Email，base64 之后不超过 512
+	EncryptedEmail EncryptedString `gorm:"size:512"`
+	// 管理员，BeforeDelete 禁止删除
+	IsAdmin bool
+	// 余额，decimal.Decimal 实现了 Valuer/Scanner，以字符串的形式读写，不会经过 float64 损失精度
+	Balance decimal.Decimal `gorm:"type:decimal(18,2);not null;default:0"`
 }
 
 func initTable(m gorm.Migrator) error {
+	// User 属于 Company，建 User 表时外键引用的 t_companies 需要已经存在
+	if err := m.AutoMigrate(&Company{}); err != nil {
+		return err
+	}
+
 	if !m.HasTable(&User{}) {
 		err := m.CreateTable(&User{})
 		if err != nil {
@@ -52,32 +100,344 @@ func initTable(m gorm.Migrator) error {
 		}
 	}
 
-	return m.AutoMigrate(&User{})
+	// AutoMigrate 只会创建缺失的列和索引，不会删除，Email 改为联合索引后需要手动删除旧的唯一索引
+	if m.HasIndex(&User{}, "idx_t_users_email") {
+		if err := m.DropIndex(&User{}, "idx_t_users_email"); err != nil {
+			return err
+		}
+	}
+
+	// AutoMigrate 会创建 Email、IsDeleted 的联合唯一索引 idx_email_deleted
+	// 以及 Name、CompanyID 的联合唯一索引 idx_company_name，Profile 需要在 User 之后创建
+	// Languages 的连接表同时引用 t_users 和 t_languages，AutoMigrate 会在两张表之后创建
+	return m.AutoMigrate(&Language{}, &User{}, &Profile{}, &Account{}, &Counter{})
+}
+
+// autoMigrate 在 ctx 下执行 initTable，大表加列、建索引可能很慢，ctx 超时或被取消导致的失败返回 ErrMigrationTimeout，
+// 与表结构本身的错误区分开
+func autoMigrate(ctx context.Context, db *gorm.DB) error {
+	err := initTable(db.WithContext(ctx).Migrator())
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", ErrMigrationTimeout, err)
+	}
+	return err
+}
+
+// translateUserError 在 translateError 的基础上把违反 idx_email_deleted 的错误翻译为 ErrEmailExists
+// Error 1062: Duplicate entry 'xxx-0' for key 'idx_email_deleted'
+func translateUserError(err error) error {
+	err = translateError(err)
+	if errors.Is(err, ErrDuplicate) && strings.Contains(err.Error(), "idx_email_deleted") {
+		return fmt.Errorf("%w: %v", ErrEmailExists, err)
+	}
+	return err
+}
+
+// createUser 创建用户，返回插入的行数，Email 重复时返回 ErrEmailExists 而不是驱动的原始错误
+func createUser(gormDb *gorm.DB, user *User) (int64, error) {
+	result := gormDb.Create(user)
+	if err := translateUserError(result.Error); err != nil {
+		return 0, err
+	}
+	return result.RowsAffected, nil
+}
+
+// updateUser 更新用户的指定字段，返回更新的行数和翻译后的错误
+// 返回 0 行表示没有匹配的记录，或者更新的值与原值相同(MySQL 默认返回实际变化的行数)
+func updateUser(gormDb *gorm.DB, user *User, values map[string]interface{}) (int64, error) {
+	result := gormDb.Model(user).Updates(values)
+	if err := translateUserError(result.Error); err != nil {
+		return 0, err
+	}
+	return result.RowsAffected, nil
+}
+
+// deleteUser 根据主键软删除用户，返回删除的行数，0 表示记录不存在或已被删除
+func deleteUser(gormDb *gorm.DB, id uint) (int64, error) {
+	result := gormDb.Delete(&User{}, id)
+	return result.RowsAffected, translateError(result.Error)
+}
+
+// createAndReload 创建用户后重新查询一次，读回数据库生成的字段
+// GORM 在插入前填充 CreatedAt、UpdateOn，插入后用 LAST_INSERT_ID() 回填主键；
+// 但 Status、Version 这类零值时由数据库 default 填充的字段，MySQL 不支持 RETURNING，结构体中仍然是零值。
+// Postgres 会使用 INSERT ... RETURNING 把这些字段一并写回结构体，不需要再查一次
+func createAndReload(gormDb *gorm.DB, user *User) error {
+	if err := gormDb.Create(user).Error; err != nil {
+		return err
+	}
+	return gormDb.First(user, user.ID).Error
+}
+
+// DefaultAge 创建用户时 Age 为 0 则使用的默认年龄，可以通过配置 User.DefaultAge 修改
+var DefaultAge uint8 = 20
+
+// cloneUser 复制一个用户，作为新的记录插入并返回，Create 会像普通创建一样调用 BeforeCreate 和创建回调
+// 清空主键和时间戳，由 GORM 重新填充；Version 置零后使用数据库的默认值，TraceID 置空后重新生成，余额不复制；
+// Email 有唯一索引，在 @ 前加上 +copy，重复复制同一个用户时返回 ErrEmailExists；
+// 属于公司的用户名字在公司内唯一，名字后加上 -copy；Company、Profile、Languages 等关联没有查询，不会复制
+func cloneUser(db *gorm.DB, srcID uint) (*User, error) {
+	src := new(User)
+	if err := db.First(src, srcID).Error; err != nil {
+		return nil, err
+	}
+
+	clone := *src
+	clone.ID = 0
+	clone.CreatedAt, clone.UpdateOn = 0, 0
+	clone.Version = 0
+	clone.TraceID = ""
+	clone.Balance = decimal.Zero
+	if src.Email != nil {
+		email := *src.Email
+		if i := strings.LastIndex(email, "@"); i >= 0 {
+			email = email[:i] + "+copy" + email[i:]
+		} else {
+			email += "+copy"
+		}
+		clone.Email = &email
+	}
+	if src.CompanyID != nil {
+		clone.Name = src.Name + "-copy"
+	}
+	if _, err := createUser(db, &clone); err != nil {
+		return nil, err
+	}
+	return &clone, nil
 }
 
 // BeforeCreate https://gorm.io/zh_CN/docs/hooks.html hook 函数
 func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 	if u.Age == 0 {
-		u.Age = 20
+		u.Age = DefaultAge
 	}
 	return
 }
 
-func main() {
-	dsn := viper.GetString("DbConfig.DSN")
+// 简单的邮箱格式校验，只要求 xxx@xxx.xxx 的形式
+var emailRegexp = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
+
+// uniqueEmail 返回带时间戳的 Email，例如 sharpe-1641103780123456789@gmail.com，Email 上有唯一索引，示例重复运行时不会冲突
+func uniqueEmail(prefix string) string {
+	return fmt.Sprintf("%s-%d@gmail.com", prefix, time.Now().UnixNano())
+}
+
+// updateValue 返回这次写入会把字段 name 设置成的值，hook 的接收者是 Statement.Model，Update/Updates 的新值在 Statement.Dest 中
+// Dest 为 map 时按字段名或列名查找；为 User 时与 GORM 的规则相同，被 Select 的字段或非零值字段才会写入；
+// Dest 为切片(批量创建)或其他类型时返回 false
+func updateValue(stmt *gorm.Statement, name string) (interface{}, bool) {
+	if stmt.Schema == nil {
+		return nil, false
+	}
+	field := stmt.Schema.LookUpField(name)
+	if field == nil {
+		return nil, false
+	}
+	if dest, ok := stmt.Dest.(map[string]interface{}); ok {
+		if value, ok := dest[field.Name]; ok {
+			return value, true
+		}
+		value, ok := dest[field.DBName]
+		return value, ok
+	}
+
+	destValue := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+	if destValue.Kind() != reflect.Struct || destValue.Type() != stmt.Schema.ModelType {
+		return nil, false
+	}
+	value, zero := field.ValueOf(destValue)
+	selectColumns, restricted := stmt.SelectAndOmitColumns(false, true)
+	if selected, ok := selectColumns[field.DBName]; ok {
+		return value, selected
+	}
+	return value, !restricted && !zero
+}
+
+// BeforeSave 创建和更新前都会调用的 hook 函数，Email 不为 nil 时校验其格式
+// 调用顺序 BeforeSave -> BeforeCreate/BeforeUpdate；更新时 Model 上的是旧值，要校验的是 Dest 中的新值，
+// 例如 db.Model(&user).Update("email", "not-an-email")；这次不写入 Email 时仍然校验 Model 上的 Email
+func (u *User) BeforeSave(tx *gorm.DB) (err error) {
+	email := u.Email
+	if value, ok := updateValue(tx.Statement, "Email"); ok {
+		switch v := value.(type) {
+		case string:
+			email = &v
+		case *string:
+			email = v
+		default:
+			// nil 写入 NULL，gorm.Expr 等表达式没法校验
+			email = nil
+		}
+	}
+	if email != nil && !emailRegexp.MatchString(*email) {
+		return fmt.Errorf("%w: %q", ErrInvalidEmail, *email)
+	}
+	return
+}
+
+// BeforeUpdate 更新前的 hook 函数，禁止把 Name 更新为空字符串
+// 直接检查 Dest 而不是用 Statement.Changed：Changed 比较的是 Model 与新值，
+// db.Model(&User{}).Where("id = ?", id).Update("name", "") 的 Model 中 Name 本来就是空的，Changed 为 false
+// Updates(User{Name: ""}) 这种零值字段会被忽略，但 Select("Name").Updates(&User{}) 或 Save 会写入空字符串
+func (u *User) BeforeUpdate(tx *gorm.DB) (err error) {
+	if value, ok := updateValue(tx.Statement, "Name"); ok && value == "" {
+		return ErrEmptyName
+	}
+	return
+}
+
+// AfterUpdate 更新后的 hook 函数，与更新语句处于同一个事务中
+func (u *User) AfterUpdate(tx *gorm.DB) (err error) {
+	fmt.Printf("AfterUpdate id = %d, UpdateOn = %d\n", u.ID, u.UpdateOn)
+	return
+}
+
+// BeforeDelete 删除前的 hook 函数，禁止删除管理员
+// hook 的接收者是传给 Delete 的值：db.Delete(&user) 中的 user 是查出来的记录，可以看到 IsAdmin；
+// 只按条件删除时，例如 db.Delete(&User{}, id) 或 db.Where(...).Delete(&User{})，接收者是空的 User，IsAdmin 为 false，拦不住
+func (u *User) BeforeDelete(tx *gorm.DB) (err error) {
+	if u.IsAdmin {
+		return fmt.Errorf("%w: %d", ErrCannotDeleteAdmin, u.ID)
+	}
+	return
+}
+
+// AfterDelete 删除后的 hook 函数，软删除用户时一同软删除 Profile，与用户删除在同一个事务中
+// UPDATE `t_profiles` SET `is_deleted`=1641373000 WHERE user_id = 1 AND `t_profiles`.`is_deleted` = 0
+// Unscoped 下删除用户执行的是 DELETE，不做级联；与 BeforeDelete 一样，只按条件删除时接收者的 ID 为 0，也不做级联
+func (u *User) AfterDelete(tx *gorm.DB) (err error) {
+	if tx.Statement.Unscoped || u.ID == 0 {
+		return
+	}
+	return tx.Where("user_id = ?", u.ID).Delete(&Profile{}).Error
+}
+
+// openDB 连接数据库，naming 决定模型对应的表名和列名，可以是 schema.NamingStrategy，也可以是自定义的 schema.Namer
+func openDB(dsn string, naming schema.Namer) (*gorm.DB, error) {
 	// 方式一 简单
 	// db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
 	// 方式二 可有更多的自定义配置(数据库驱动程序提供了 一些高级配置 可以在初始化过程中使用)
-	db, err := gorm.Open(mysql.New(mysql.Config{DSN: dsn}), &gorm.Config{ // https://gorm.io/zh_CN/docs/gorm_config.html
+	return gorm.Open(mysql.New(mysql.Config{DSN: dsn}), &gorm.Config{ // https://gorm.io/zh_CN/docs/gorm_config.html
 		SkipDefaultTransaction: false, //跳过默认事务
-		NamingStrategy: schema.NamingStrategy{
-			TablePrefix:   "t_",  // 表名前缀
-			SingularTable: false, // 使用单数表名
-		},
+		// 缓存预编译的语句，相同的 SQL 只 Prepare 一次，省去每次执行前的 Prepare 往返，默认 false
+		// 每个不同的 SQL 都会占用一个语句，IN 的参数个数不同也是不同的 SQL，缓存只增不减，
+		// 语句数量受 MySQL 的 max_prepared_stmt_count 限制，SQL 种类很多时需要调用 closePreparedStatements 清理
+		PrepareStmt: viper.GetBool("DbConfig.PrepareStmt"),
+		// 与默认 logger 的级别相同，SQL 出错和慢 SQL 时输出，ctx 中有请求 ID 时会带上
+		Logger:         newRequestIDLogger(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Warn),
+		NamingStrategy: naming,
 	})
+}
+
+// closePreparedStatements 关闭并清空缓存的预编译语句，之后执行的 SQL 会重新 Prepare，没有开启 PrepareStmt 时什么都不做
+func closePreparedStatements(db *gorm.DB) error {
+	for _, pool := range []gorm.ConnPool{db.ConnPool, db.Statement.ConnPool} {
+		if stmts, ok := pool.(*gorm.PreparedStmtDB); ok {
+			stmts.Close()
+			return nil
+		}
+	}
+	return nil
+}
+
+// newNamingStrategy 从配置中读取命名策略
+// SingularTable 为 true 时 User 对应 t_user，NoLowerCase 为 true 时列名与字段名相同，例如 MemberNumber，
+// 示例中手写的 SQL 使用的都是 t_users 和蛇形列名，这两个选项只适合新的项目
+// NameReplacer 为成对的替换规则，在转换为蛇形之前应用，例如 ["Number", "No"] 让 MemberNumber 对应 member_no
+func newNamingStrategy() schema.NamingStrategy {
+	naming := schema.NamingStrategy{
+		TablePrefix:   "t_",                                    // 表名前缀
+		SingularTable: viper.GetBool("DbConfig.SingularTable"), // 使用单数表名，默认 false
+		NoLowerCase:   viper.GetBool("DbConfig.NoLowerCase"),   // 不转换为蛇形，默认 false
+	}
+	if pairs := viper.GetStringSlice("DbConfig.NameReplacer"); len(pairs) > 0 && len(pairs)%2 == 0 {
+		naming.NameReplacer = strings.NewReplacer(pairs...)
+	}
+	return naming
+}
+
+// openDBWithPrefix 使用指定的表名前缀连接数据库，例如测试或多租户使用不同的前缀，prefix 为 a_ 时 User 对应 a_users
+func openDBWithPrefix(dsn, prefix string) (*gorm.DB, error) {
+	return openDB(dsn, schema.NamingStrategy{TablePrefix: prefix})
+}
+
+var (
+	dbOnce      sync.Once
+	sharedDB    *gorm.DB
+	sharedDBErr error
+	// openSharedDB DB() 第一次调用时用它建立连接
+	openSharedDB = func() (*gorm.DB, error) {
+		return openDB(viper.GetString("DbConfig.DSN"), newNamingStrategy())
+	}
+)
+
+// DB 返回全局共享的连接，第一次调用时才连接数据库，并发调用时只会连接一次
+// 连接失败的错误同样会被缓存，之后每次调用都返回同一个错误，不会重试
+// 与在 main 中打开再逐层传递 *gorm.DB 相比写起来省事，但依赖变成了隐式的，测试时也不方便替换
+func DB() (*gorm.DB, error) {
+	dbOnce.Do(func() {
+		sharedDB, sharedDBErr = openSharedDB()
+	})
+	return sharedDB, sharedDBErr
+}
+
+func main() {
+	// go run ./internal -demo create|query|update|delete|all
+	demo := flag.String("demo", "query", "which demo to run, all runs create, query, update and delete")
+	flag.Usage = usage
+	flag.Parse()
+	if _, ok := demos[*demo]; !ok && *demo != "all" {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown demo %q\n", *demo)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// 没有配置时为 0，保留 DefaultAge 的默认值
+	if age := viper.GetUint("User.DefaultAge"); age > math.MaxUint8 {
+		fmt.Printf("User.DefaultAge %d out of range 0-255\n", age)
+		return
+	} else if age > 0 {
+		DefaultAge = uint8(age)
+	}
+
+	db, err := openDB(viper.GetString("DbConfig.DSN"), newNamingStrategy())
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if err = registerMetrics(db); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if interval := viper.GetDuration("DbConfig.StatsLogInterval"); interval > 0 {
+		go logDBStats(context.Background(), db, interval)
+	}
+	if err = useReadReplicas(db, viper.GetStringSlice("DbConfig.ReplicaDSNs")); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = registerAuditCallbacks(db); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = registerTraceIDCallbacks(db); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = registerWriteCounter(db); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if key := viper.GetString("Crypto.EncryptionKey"); key != "" {
+		if err = setEncryptionKey(key); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
 
 	// Migrator 接口，该接口为每个数据库提供了统一的 API 接口，可用来为您的数据库构建独立迁移
-	m := db.Migrator()
+	// m := db.Migrator()
 
 	// 反复横跳
 	/*	if !m.HasTable(&User{}) { // 等价于 m.HasTable("t_users")
@@ -87,18 +447,159 @@ func main() {
 			// 建表
 			err = m.CreateTable(&User{})
 		}*/
-	err = initTable(m)
+	migrateCtx := context.Background()
+	if timeout := viper.GetDuration("DbConfig.MigrateTimeout"); timeout > 0 {
+		var cancel context.CancelFunc
+		migrateCtx, cancel = context.WithTimeout(migrateCtx, timeout)
+		defer cancel()
+	}
+	err = autoMigrate(migrateCtx, db)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// strict 模式下检查模型的字段都已经迁移，避免查询时才报 Unknown column
+	if viper.GetBool("DbConfig.StrictSchema") {
+		if err = verifySchema(db, &Company{}, &Language{}, &User{}, &Profile{}, &Account{}); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
+	// 空表时 testQuery 会直接返回 RecordNotFound，先插入一些示例数据
+	err = seedUsers(db, 20)
 	if err != nil {
 		fmt.Println(err.Error())
 		return
 	}
 
 	// Test CRUD
-	//testCreate(db)
-	//testQuery(db)
-	//testUpdate(db)
-	//testDelete(db)
-	testTransaction(db)
+	if *demo == "all" {
+		for _, name := range []string{"create", "query", "update", "delete"} {
+			fmt.Printf("==== %s ====\n", name)
+			demos[name](db)
+		}
+		return
+	}
+	demos[*demo](db)
+}
+
+// demos 可以通过 -demo 参数运行的示例
+var demos = map[string]func(*gorm.DB){
+	"create":                   testCreate,
+	"query":                    testQuery,
+	"update":                   testUpdate,
+	"delete":                   testDelete,
+	"transaction":              testTransaction,
+	"hook":                     testHook,
+	"email-validation":         testEmailValidation,
+	"unique-email":             testUniqueEmail,
+	"translate-error":          func(*gorm.DB) { testTranslateError() },
+	"company-unique-name":      testCompanyUniqueName,
+	"json-settings":            testJSONSettings,
+	"status":                   testStatus,
+	"account":                  testAccount,
+	"scopes":                   testScopes,
+	"explain-sql":              testExplainSQL,
+	"locking":                  testLocking,
+	"optimistic-lock":          testOptimisticLock,
+	"upsert-in-batches":        testUpsertInBatches,
+	"rows-affected":            testRowsAffected,
+	"user-filter":              testUserFilter,
+	"keyset-pagination":        testKeysetPagination,
+	"user-dto":                 testUserDTO,
+	"repository-with-table":    testRepositoryWithTable,
+	"create-and-reload":        testCreateAndReload,
+	"query-with-timeout":       testQueryWithTimeout,
+	"metrics":                  testMetrics,
+	"tracing":                  testTracing,
+	"restore":                  testRestore,
+	"purge-inactive":           testPurgeInactive,
+	"hard-delete-expired":      testHardDeleteExpired,
+	"sub-query":                testSubQuery,
+	"joins":                    testJoins,
+	"profile-completion-stats": testProfileCompletionStats,
+	"generic-repository":       testGenericRepository,
+	"user-batcher":             testUserBatcher,
+	"read-replicas":            testReadReplicas,
+	"cached-repository":        testCachedRepository,
+	"audit":                    testAudit,
+	"list-as-map":              testListAsMap,
+	"increment-age":            testIncrementAge,
+	"soft-delete-unique-email": testSoftDeleteUniqueEmail,
+	"named-scopes":             testNamedScopes,
+	"apply-sort":               testApplySort,
+	"user-exists":              testUserExists,
+	"context-cancel":           testContextCancel,
+	"migrations":               testMigrations,
+	"rollback-migration":       testRollbackMigration,
+	"bulk-update-ages":         testBulkUpdateAges,
+	"user-counts":              testUserCounts,
+	"session":                  testSession,
+	"to-sql":                   testToSQL,
+	"encrypted-email":          testEncryptedEmail,
+	"request-id-logger":        testRequestIDLogger,
+	"preload-languages":        testPreloadLanguages,
+	"stream-users":             testStreamUsers,
+	"table-prefix":             testTablePrefix,
+	"singular-table":           testSingularTable,
+	"verify-schema":            testVerifySchema,
+	"insert-ignore":            testInsertIgnore,
+	"before-delete":            testBeforeDelete,
+	"repository-upsert":        testUpsert,
+	"created-between":          testCreatedBetween,
+	"serve":                    serveUsers,
+	"user-handler":             testUserHandler,
+	"user-write-handler":       testUserWriteHandler,
+	"user-service":             testUserService,
+	"export-users-csv":         testExportUsersCSV,
+	"import-users-csv":         testImportUsersCSV,
+	"or-group":                 testOrGroup,
+	"with-retry":               testWithRetry,
+	"trace-id":                 testTraceID,
+	"age-stats":                testAgeStats,
+	"balance":                  testBalance,
+	"find-including-deleted":   testFindIncludingDeleted,
+	"repository-find":          testRepositoryFind,
+	"no-lower-case":            testNoLowerCase,
+	"find-by-ids":              testFindByIDs,
+	"preload-sorted-languages": testPreloadSortedLanguages,
+	"truncate-all":             testTruncateAll,
+	"db-stats":                 testDBStats,
+	"migration-timeout":        testMigrationTimeout,
+	"increment-counter":        testIncrementCounter,
+	"cascade-soft-delete":      testCascadeSoftDelete,
+	"slow-query-tracer":        testSlowQueryTracer,
+	"delete-by-ids":            testDeleteByIDs,
+	"scan-into":                testScanInto,
+	"repository-model":         testRepositoryModel,
+	"find-age-range":           testFindAgeRange,
+	"search-by-name":           testSearchByName,
+	"null-fields":              testNullFields,
+	"write-counter":            testWriteCounter,
+	"sql-files":                testSQLFiles,
+	"read-only":                testReadOnly,
+	"user-input-output":        testUserInputOutput,
+	"default-age":              testDefaultAge,
+	"n-plus-one":               testNPlusOne,
+	"user-string":              testUserString,
+	"shared-db":                testSharedDB,
+	"not":                      testNot,
+	"list-sorted":              testListSorted,
+	"update-name":              testUpdateName,
+	"prepare-stmt":             testPrepareStmt,
+	"clone-user":               testCloneUser,
+}
+
+func usage() {
+	names := make([]string, 0, len(demos))
+	for name := range demos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -demo <name>\n  all (create, query, update and delete)\n  %s\n",
+		os.Args[0], strings.Join(names, "\n  "))
+	flag.PrintDefaults()
 }
 
 func testCreate(gormDb *gorm.DB) {
@@ -116,7 +617,7 @@ func testCreate(gormDb *gorm.DB) {
 		fmt.Println(result.Error.Error())
 		return
 	}
-	mail := "test@gmail.com"
+	mail := uniqueEmail("test")
 	user2 := User{
 		Name:     "sharpe-x-2",
 		Age:      19,
@@ -234,6 +735,7 @@ func testCreate(gormDb *gorm.DB) {
 	//标签 default 为字段定义默认值
 	// `gorm:"default:default@gmail.com"`
 	// 插入记录到数据库时，默认值 会被用于 填充值为 零值 的字段
+	// 注意：有唯一索引的字段不要设置默认值，否则第二个零值记录就会冲突
 
 	// Upsert 及冲突
 	// TODO
@@ -460,7 +962,7 @@ func testQuery(gormDb *gorm.DB) {
 		return
 	}
 	fmt.Printf("findAllUser len =  %d , findAllUser = %v\n", len(findAllUser), findAllUser)
-	// Not 条件 用法与 Where 类似
+	// Not 条件 用法与 Where 类似，见 filter.go 中的 findNotNamed 和 testNot
 	// Or 条件
 	/*db.Where("role = ?", "admin").Or("role = ?", "super_admin").Find(&users)
 	// SELECT * FROM users WHERE role = 'admin' OR role = 'super_admin';
@@ -636,8 +1138,8 @@ func testUpdate(gormDb *gorm.DB) {
 
 	// 更新多列
 	// 当使用 struct 更新时，默认情况下，GORM 只会更新非零值的字段
-	// UPDATE `t_users` SET `name`='hello-update',`email`='hello-update@gmail.com',`update_on`=1641214897 WHERE `id` = 217
-	mail := "hello-update@gmail.com"
+	// UPDATE `t_users` SET `name`='hello-update',`email`='hello-update-1641214897000000000@gmail.com',`update_on`=1641214897 WHERE `id` = 217
+	mail := uniqueEmail("hello-update")
 	result = gormDb.Model(&lastUser).Updates(User{
 		Name:  "hello-update",
 		Email: &mail,
@@ -850,3 +1352,577 @@ func transaction(tx *gorm.DB) error {
 
 	return nil
 }
+
+func testHook(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-hook"}
+	result := gormDb.Create(&user)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	// BeforeUpdate 返回错误时 GORM 会回滚默认事务，更新不会生效
+	// UPDATE `t_users` SET `name`='',`update_on`=1641373000 WHERE `t_users`.`is_deleted` = 0 AND `id` = 21 不会被执行
+	result = gormDb.Model(&user).Update("name", "")
+	if !errors.Is(result.Error, ErrEmptyName) {
+		fmt.Printf("expect ErrEmptyName, got %v\n", result.Error)
+		return
+	}
+
+	result = gormDb.Model(&user).Updates(map[string]interface{}{"name": "", "age": 30})
+	if !errors.Is(result.Error, ErrEmptyName) {
+		fmt.Printf("expect ErrEmptyName, got %v\n", result.Error)
+		return
+	}
+
+	// 没有先查询记录，Model 中的 Name 本来就是空的，同样要拦住
+	result = gormDb.Model(&User{}).Where("id = ?", user.ID).Update("name", "")
+	if !errors.Is(result.Error, ErrEmptyName) {
+		fmt.Printf("expect ErrEmptyName without a loaded model, got %v\n", result.Error)
+		return
+	}
+	// Select 之后零值字段也会写入
+	result = gormDb.Model(&User{ID: user.ID}).Select("Name").Updates(&User{})
+	if !errors.Is(result.Error, ErrEmptyName) {
+		fmt.Printf("expect ErrEmptyName for a selected zero Name, got %v\n", result.Error)
+		return
+	}
+
+	hookUser := new(User)
+	result = gormDb.First(hookUser, user.ID)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	// Name 与 Age 都保持原样
+	fmt.Printf("after rollback hookUser = %+v\n", hookUser)
+
+	// 正常更新会触发 AfterUpdate
+	result = gormDb.Model(&user).Update("name", "sharpe-hook-2")
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+}
+
+func testEmailValidation(gormDb *gorm.DB) {
+	validEmail := uniqueEmail("sharpe")
+	validUser := User{Name: "sharpe-valid-email", Email: &validEmail}
+	result := gormDb.Create(&validUser)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	fmt.Printf("validUser = %+v\n", validUser)
+
+	invalidEmail := "sharpe.gmail.com"
+	invalidUser := User{Name: "sharpe-invalid-email", Email: &invalidEmail}
+	result = gormDb.Create(&invalidUser)
+	if !errors.Is(result.Error, ErrInvalidEmail) {
+		fmt.Printf("expect ErrInvalidEmail, got %v\n", result.Error)
+		return
+	}
+	// invalid email "sharpe.gmail.com"
+	fmt.Println(result.Error.Error())
+
+	// Email 为 nil 时不校验，插入时写入 NULL
+	nilEmailUser := User{Name: "sharpe-nil-email"}
+	result = gormDb.Create(&nilEmailUser)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	fmt.Printf("nilEmailUser = %+v\n", nilEmailUser)
+
+	// Update/Updates 时 Model 上的 Email 是合法的旧值，校验的是要写入的新值
+	result = gormDb.Model(&validUser).Update("email", "not-an-email")
+	if !errors.Is(result.Error, ErrInvalidEmail) {
+		fmt.Printf("expect ErrInvalidEmail for Update, got %v\n", result.Error)
+		return
+	}
+	result = gormDb.Model(&validUser).Updates(map[string]interface{}{"email": "not-an-email"})
+	if !errors.Is(result.Error, ErrInvalidEmail) {
+		fmt.Printf("expect ErrInvalidEmail for Updates, got %v\n", result.Error)
+		return
+	}
+
+	// Save 同样会触发 BeforeSave
+	validUser.Email = &invalidEmail
+	result = gormDb.Save(&validUser)
+	if !errors.Is(result.Error, ErrInvalidEmail) {
+		fmt.Printf("expect ErrInvalidEmail, got %v\n", result.Error)
+		return
+	}
+}
+
+func testUniqueEmail(gormDb *gorm.DB) {
+	email := uniqueEmail("sharpe-unique")
+	_, err := createUser(gormDb, &User{Name: "sharpe-unique-1", Email: &email})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// 直接 Create 得到的是 MySQL 原始错误
+	// Error 1062: Duplicate entry 'sharpe-unique-1641103780123456789@gmail.com-0' for key 'idx_email_deleted'
+	err = gormDb.Create(&User{Name: "sharpe-unique-2", Email: &email}).Error
+	if !errors.Is(translateError(err), ErrDuplicate) {
+		fmt.Printf("expect duplicate-key error, got %v\n", err)
+		return
+	}
+	fmt.Println(err.Error())
+
+	_, err = createUser(gormDb, &User{Name: "sharpe-unique-2", Email: &email})
+	if !errors.Is(err, ErrEmailExists) {
+		fmt.Printf("expect ErrEmailExists, got %v\n", err)
+		return
+	}
+
+	// 多个 NULL 不会违反唯一索引
+	for _, name := range []string{"sharpe-null-email-1", "sharpe-null-email-2"} {
+		if _, err = createUser(gormDb, &User{Name: name}); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+}
+
+func testRowsAffected(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-rows-affected"}
+	rows, err := createUser(gormDb, &user)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if rows != 1 {
+		fmt.Printf("create: expect 1 row, got %d\n", rows)
+		return
+	}
+
+	rows, err = updateUser(gormDb, &user, map[string]interface{}{"age": 33})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if rows != 1 {
+		fmt.Printf("update: expect 1 row, got %d\n", rows)
+		return
+	}
+
+	// 多行写入
+	users := []User{{Name: "sharpe-rows-1", Age: 40}, {Name: "sharpe-rows-2", Age: 40}, {Name: "sharpe-rows-3", Age: 40}}
+	result := gormDb.Create(&users)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	if result.RowsAffected != int64(len(users)) {
+		fmt.Printf("batch create: expect %d rows, got %d\n", len(users), result.RowsAffected)
+		return
+	}
+	result = gormDb.Model(&User{}).Where("name IN ?", []string{"sharpe-rows-1", "sharpe-rows-2", "sharpe-rows-3"}).Update("age", 41)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	if result.RowsAffected != int64(len(users)) {
+		fmt.Printf("batch update: expect %d rows, got %d\n", len(users), result.RowsAffected)
+		return
+	}
+
+	rows, err = deleteUser(gormDb, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if rows != 1 {
+		fmt.Printf("delete: expect 1 row, got %d\n", rows)
+		return
+	}
+	// 已经删除的记录不会再被匹配
+	rows, err = deleteUser(gormDb, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if rows != 0 {
+		fmt.Printf("delete again: expect 0 rows, got %d\n", rows)
+		return
+	}
+}
+
+func testCreateAndReload(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-reload"}
+	result := gormDb.Create(&user)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	// Create 之后 ID、CreatedAt、UpdateOn 已经可以读到，而数据库默认值没有写回
+	// user = {ID:21 CreatedAt:1641373000 UpdateOn:1641373000 Status:Status(0) Version:0}
+	fmt.Printf("user = {ID:%d CreatedAt:%d UpdateOn:%d Status:%v Version:%d}\n",
+		user.ID, user.CreatedAt, user.UpdateOn, user.Status, user.Version)
+
+	reloadUser := User{Name: "sharpe-reload-2"}
+	if err := createAndReload(gormDb, &reloadUser); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if reloadUser.ID == 0 || reloadUser.CreatedAt == 0 || reloadUser.UpdateOn == 0 {
+		fmt.Printf("expect id and timestamps to be populated, got %+v\n", reloadUser)
+		return
+	}
+	// BeforeCreate 设置的默认年龄同样会写入
+	if reloadUser.Age != DefaultAge {
+		fmt.Printf("expect age = %d, got %d\n", DefaultAge, reloadUser.Age)
+		return
+	}
+	if reloadUser.Status != StatusActive || reloadUser.Version != 1 {
+		fmt.Printf("expect default status and version, got %v %d\n", reloadUser.Status, reloadUser.Version)
+		return
+	}
+	// Email 为 nil 时写入 NULL，读回来仍然是 nil
+	if reloadUser.Email != nil {
+		fmt.Printf("expect nil email, got %s\n", *reloadUser.Email)
+		return
+	}
+	fmt.Printf("reloadUser = %+v\n", reloadUser)
+}
+
+func testSoftDeleteUniqueEmail(gormDb *gorm.DB) {
+	email := uniqueEmail("sharpe-reuse")
+	user := User{Name: "sharpe-reuse-1", Email: &email}
+	if _, err := createUser(gormDb, &user); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 两个未删除的用户不能使用相同的 Email
+	if _, err := createUser(gormDb, &User{Name: "sharpe-reuse-2", Email: &email}); !errors.Is(err, ErrEmailExists) {
+		fmt.Printf("expect ErrEmailExists, got %v\n", err)
+		return
+	}
+
+	// 软删除后 Email 可以被重新注册
+	if _, err := deleteUser(gormDb, user.ID); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if _, err := createUser(gormDb, &User{Name: "sharpe-reuse-3", Email: &email}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+}
+
+func testTablePrefix(gormDb *gorm.DB) {
+	dsn := viper.GetString("DbConfig.DSN")
+	dbA, err := openDBWithPrefix(dsn, "a_")
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	dbB, err := openDBWithPrefix(dsn, "b_")
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// a_users 与 t_users 结构相同，b_users 不存在
+	if err = gormDb.Exec("CREATE TABLE IF NOT EXISTS `a_users` LIKE `t_users`").Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = gormDb.Exec("DROP TABLE IF EXISTS `b_users`").Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if !dbA.Migrator().HasTable(&User{}) || dbB.Migrator().HasTable(&User{}) {
+		fmt.Println("expect User to resolve to a_users and b_users respectively")
+		return
+	}
+
+	// SELECT * FROM `a_users` WHERE `a_users`.`is_deleted` = 0
+	sql := explainSQL(dbA, func(tx *gorm.DB) *gorm.DB {
+		return tx.Find(&[]User{})
+	})
+	if !strings.Contains(sql, "FROM `a_users`") {
+		fmt.Printf("expect query on a_users, got %s\n", sql)
+		return
+	}
+	fmt.Println(sql)
+}
+
+func testSingularTable(gormDb *gorm.DB) {
+	dsn := viper.GetString("DbConfig.DSN")
+	for _, singular := range []bool{true, false} {
+		db, err := openDB(dsn, schema.NamingStrategy{TablePrefix: "t_", SingularTable: singular})
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+
+		want := "t_users"
+		if singular {
+			want = "t_user"
+			// t_user 与 t_users 结构相同，演示结束后删除
+			if err = gormDb.Exec("CREATE TABLE IF NOT EXISTS `t_user` LIKE `t_users`").Error; err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+		}
+
+		stmt := &gorm.Statement{DB: db}
+		if err = stmt.Parse(&User{}); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		sql := explainSQL(db, func(tx *gorm.DB) *gorm.DB {
+			return tx.Find(&[]User{})
+		})
+		if stmt.Table != want || !strings.Contains(sql, "FROM `"+want+"`") || !db.Migrator().HasTable(&User{}) {
+			fmt.Printf("SingularTable = %v, expect %s, got %s, sql = %s\n", singular, want, stmt.Table, sql)
+			return
+		}
+		fmt.Printf("SingularTable = %v, table = %s\n", singular, stmt.Table)
+	}
+
+	if err := gormDb.Exec("DROP TABLE IF EXISTS `t_user`").Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+}
+
+func testDefaultAge(gormDb *gorm.DB) {
+	defer func(age uint8) { DefaultAge = age }(DefaultAge)
+	DefaultAge = 25
+
+	users := []User{{Name: "sharpe-default-age"}, {Name: "sharpe-explicit-age", Age: 30}}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 只有 Age 为 0 时才使用默认值
+	if users[0].Age != 25 || users[1].Age != 30 {
+		fmt.Printf("expect ages 25 and 30, got %d and %d\n", users[0].Age, users[1].Age)
+		return
+	}
+}
+
+func testSharedDB(gormDb *gorm.DB) {
+	open := openSharedDB
+	defer func() {
+		openSharedDB = open
+		dbOnce, sharedDB, sharedDBErr = sync.Once{}, nil, nil
+	}()
+
+	var opens int64
+	dbOnce, sharedDB, sharedDBErr = sync.Once{}, nil, nil
+	openSharedDB = func() (*gorm.DB, error) {
+		atomic.AddInt64(&opens, 1)
+		// 放大并发调用同时进入的时间窗口
+		time.Sleep(50 * time.Millisecond)
+		return gormDb, nil
+	}
+
+	var wg sync.WaitGroup
+	dbs := make([]*gorm.DB, 20)
+	for i := range dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dbs[i], _ = DB()
+		}(i)
+	}
+	wg.Wait()
+	if opens != 1 {
+		fmt.Printf("expect 1 open, got %d\n", opens)
+		return
+	}
+	for _, db := range dbs {
+		if db != gormDb {
+			fmt.Println("expect every caller to get the shared db")
+			return
+		}
+	}
+
+	// 失败也只连接一次，之后返回同一个错误
+	opens = 0
+	dbOnce, sharedDB, sharedDBErr = sync.Once{}, nil, nil
+	openErr := errors.New("connection refused")
+	openSharedDB = func() (*gorm.DB, error) {
+		atomic.AddInt64(&opens, 1)
+		return nil, openErr
+	}
+	for i := 0; i < 2; i++ {
+		if db, err := DB(); db != nil || !errors.Is(err, openErr) {
+			fmt.Printf("expect the cached error, got %v, %v\n", db, err)
+			return
+		}
+	}
+	if opens != 1 {
+		fmt.Printf("expect 1 open after failure, got %d\n", opens)
+		return
+	}
+}
+
+func testPrepareStmt(gormDb *gorm.DB) {
+	// PrepareStmt 只能在打开时设置，使用一个单独的 Config
+	db, err := standaloneDB(gormDb, &gorm.Config{PrepareStmt: true})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	stmts, ok := db.ConnPool.(*gorm.PreparedStmtDB)
+	if !ok {
+		fmt.Printf("expect *gorm.PreparedStmtDB, got %T\n", db.ConnPool)
+		return
+	}
+
+	// 参数不同，SQL 相同，只 Prepare 一次
+	// SELECT * FROM `t_users` WHERE age > ? AND `t_users`.`is_deleted` = 0
+	for _, age := range []int{10, 20, 30} {
+		if err = db.Where("age > ?", age).Find(&[]User{}).Error; err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+	if len(stmts.Stmts) != 1 {
+		fmt.Printf("expect 1 prepared statement, got %d\n", len(stmts.Stmts))
+		return
+	}
+
+	if err = closePreparedStatements(db); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(stmts.Stmts) != 0 {
+		fmt.Printf("expect no prepared statement after close, got %d\n", len(stmts.Stmts))
+		return
+	}
+	// 关闭后仍然可以查询，会重新 Prepare
+	if err = db.Where("age > ?", 40).Find(&[]User{}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(stmts.Stmts) != 1 {
+		fmt.Printf("expect the statement to be prepared again, got %d\n", len(stmts.Stmts))
+		return
+	}
+}
+
+func testCloneUser(gormDb *gorm.DB) {
+	email := fmt.Sprintf("sharpe-clone-%d@example.com", time.Now().UnixNano())
+	src := User{
+		Name:         "sharpe-clone",
+		Email:        &email,
+		Age:          33,
+		MemberNumber: sql.NullString{String: "M-003", Valid: true},
+		Balance:      decimal.NewFromInt(100),
+	}
+	if err := gormDb.Create(&src).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	clone, err := cloneUser(gormDb, src.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	loaded := new(User)
+	if err = gormDb.First(loaded, clone.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	wantEmail := strings.Replace(email, "@", "+copy@", 1)
+	if loaded.ID == src.ID || loaded.Email == nil || *loaded.Email != wantEmail {
+		fmt.Printf("expect a new user with email %s, got %v\n", wantEmail, loaded)
+		return
+	}
+	if loaded.Name != src.Name || loaded.Age != src.Age || loaded.MemberNumber != src.MemberNumber {
+		fmt.Printf("expect name, age and member number to be copied, got %v\n", loaded)
+		return
+	}
+	// 时间戳重新生成，默认值和创建回调照常生效
+	if loaded.CreatedAt == 0 || loaded.Version != 1 || !loaded.Balance.IsZero() || (src.TraceID != "" && loaded.TraceID == src.TraceID) {
+		fmt.Printf("expect fresh timestamps, version 1, zero balance and a new trace id, got %v, %d, %s, %s\n", loaded, loaded.Version, loaded.Balance, loaded.TraceID)
+		return
+	}
+
+	// 再复制一次 Email 就重复了
+	if _, err = cloneUser(gormDb, src.ID); !errors.Is(err, ErrEmailExists) {
+		fmt.Printf("expect ErrEmailExists for a second copy, got %v\n", err)
+		return
+	}
+}
+
+func testBeforeDelete(gormDb *gorm.DB) {
+	users := []User{{Name: "sharpe-delete-normal"}, {Name: "sharpe-delete-admin", IsAdmin: true}}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// 先查出记录再删除，hook 才能看到 IsAdmin
+	var normal, admin User
+	if err := gormDb.First(&normal, users[0].ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.Delete(&normal).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if err := gormDb.First(&admin, users[1].ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.Delete(&admin).Error; !errors.Is(err, ErrCannotDeleteAdmin) {
+		fmt.Printf("expect ErrCannotDeleteAdmin, got %v\n", err)
+		return
+	}
+	// hook 返回错误时事务回滚，管理员仍然存在
+	if err := gormDb.First(&User{}, admin.ID).Error; err != nil {
+		fmt.Printf("expect admin to still exist, got %v\n", err)
+		return
+	}
+}
+
+func testNoLowerCase(gormDb *gorm.DB) {
+	db, err := openDB(viper.GetString("DbConfig.DSN"), schema.NamingStrategy{TablePrefix: "t_", NoLowerCase: true})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err = stmt.Parse(&User{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 表名同样不转换为小写
+	field := stmt.Schema.LookUpField("MemberNumber")
+	if field == nil || field.DBName != "MemberNumber" || stmt.Table != "t_Users" {
+		fmt.Printf("expect column MemberNumber in t_Users, got %+v in %s\n", field, stmt.Table)
+		return
+	}
+
+	// SELECT `MemberNumber` FROM `t_Users` WHERE `t_Users`.`IsDeleted` = 0
+	sql := explainSQL(db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&User{}).Select("MemberNumber").Find(&[]User{})
+	})
+	fmt.Println(sql)
+}
+
+func testMigrationTimeout(gormDb *gorm.DB) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := autoMigrate(ctx, gormDb); !errors.Is(err, ErrMigrationTimeout) {
+		fmt.Printf("expect ErrMigrationTimeout, got %v\n", err)
+		return
+	}
+	if err := autoMigrate(context.Background(), gormDb); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// gorm.io/plugin/prometheus 需要 gorm v1.25 以上，这里按照它的思路实现一个只采集连接池状态的插件
+// 插件需要实现 gorm.Plugin 接口，通过 db.Use 注册后会出现在 db.Config.Plugins 中
+type metricsPlugin struct {
+	dbName          string
+	refreshInterval time.Duration
+	registerer      prometheus.Registerer
+
+	maxOpenConnections prometheus.Gauge
+	openConnections    prometheus.Gauge
+	inUse              prometheus.Gauge
+	idle               prometheus.Gauge
+	waitCount          prometheus.Gauge
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMetricsPlugin(dbName string, refreshInterval time.Duration, registerer prometheus.Registerer) *metricsPlugin {
+	gauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "gorm_dbstats_" + name,
+			Help:        help,
+			ConstLabels: prometheus.Labels{"db": dbName},
+		})
+	}
+	return &metricsPlugin{
+		dbName:             dbName,
+		refreshInterval:    refreshInterval,
+		registerer:         registerer,
+		maxOpenConnections: gauge("max_open_connections", "Maximum number of open connections to the database."),
+		openConnections:    gauge("open_connections", "The number of established connections both in use and idle."),
+		inUse:              gauge("in_use", "The number of connections currently in use."),
+		idle:               gauge("idle", "The number of idle connections."),
+		waitCount:          gauge("wait_count", "The total number of connections waited for."),
+		stop:               make(chan struct{}),
+	}
+}
+
+func (p *metricsPlugin) Name() string {
+	return "gorm:prometheus"
+}
+
+func (p *metricsPlugin) collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.maxOpenConnections, p.openConnections, p.inUse, p.idle, p.waitCount}
+}
+
+// Initialize db.Use 时调用，注册指标并启动定时刷新，直到调用 Close
+func (p *metricsPlugin) Initialize(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	// 中途注册失败时注销已经注册的指标，否则下一次 db.Use 会因为 AlreadyRegistered 失败
+	for i, collector := range p.collectors() {
+		if err = p.registerer.Register(collector); err != nil {
+			for _, registered := range p.collectors()[:i] {
+				p.registerer.Unregister(registered)
+			}
+			return err
+		}
+	}
+
+	refresh := func() {
+		stats := sqlDB.Stats()
+		p.maxOpenConnections.Set(float64(stats.MaxOpenConnections))
+		p.openConnections.Set(float64(stats.OpenConnections))
+		p.inUse.Set(float64(stats.InUse))
+		p.idle.Set(float64(stats.Idle))
+		p.waitCount.Set(float64(stats.WaitCount))
+	}
+	refresh()
+	go func() {
+		ticker := time.NewTicker(p.refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				refresh()
+			}
+		}
+	}()
+	return nil
+}
+
+// Close 停止定时刷新并注销指标，可以重复调用；插件仍然留在 db.Config.Plugins 中
+func (p *metricsPlugin) Close() {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+		for _, collector := range p.collectors() {
+			p.registerer.Unregister(collector)
+		}
+	})
+}
+
+// registerMetrics 按配置注册连接池指标，DbConfig.Metrics.Enabled 默认为 false
+// DbConfig:
+//
+//	Metrics:
+//	  Enabled: true
+//	  DBName: gorm101
+//	  RefreshInterval: 15s
+func registerMetrics(db *gorm.DB) error {
+	if !viper.GetBool("DbConfig.Metrics.Enabled") {
+		return nil
+	}
+	refreshInterval := viper.GetDuration("DbConfig.Metrics.RefreshInterval")
+	if refreshInterval <= 0 {
+		refreshInterval = 15 * time.Second
+	}
+	return db.Use(newMetricsPlugin(viper.GetString("DbConfig.Metrics.DBName"), refreshInterval, prometheus.DefaultRegisterer))
+}
+
+// dbStats 返回底层 *sql.DB 的连接池状态
+func dbStats(db *gorm.DB) (sql.DBStats, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+	return sqlDB.Stats(), nil
+}
+
+// logDBStats 每隔 interval 输出一次连接池状态，直到 ctx 结束，配置 DbConfig.StatsLogInterval 大于 0 时在 main 中启动
+// WaitCount 持续增长说明连接数不够用，需要调大 MaxOpenConns
+func logDBStats(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats, err := dbStats(db)
+			if err != nil {
+				fmt.Println(err.Error())
+				continue
+			}
+			fmt.Printf("db stats: open = %d, in use = %d, wait count = %d\n", stats.OpenConnections, stats.InUse, stats.WaitCount)
+		}
+	}
+}
+
+var (
+	writeCountsMu sync.Mutex
+	writeCounts   = make(map[string]int64)
+)
+
+// WriteCounts 返回从启动到现在每张表成功执行的写语句条数，返回的是副本，可以随意修改
+func WriteCounts() map[string]int64 {
+	writeCountsMu.Lock()
+	defer writeCountsMu.Unlock()
+	counts := make(map[string]int64, len(writeCounts))
+	for table, count := range writeCounts {
+		counts[table] = count
+	}
+	return counts
+}
+
+// registerWriteCounter 在 Create、Update、Delete 之后按表名计数，一条语句计一次，与影响的行数无关；执行失败的语句不计数
+// 创建时一同创建的关联(例如 Profile)会单独执行一条语句，计到关联的表上；软删除走的是 Delete，计为一次删除
+// 与 countQueries 相同，DryRun 和没有生成 SQL 的语句(例如 Updates 没有要更新的字段)没有写数据库，不计数
+// 在默认事务提交之后计数，AfterCreate 等 hook 返回错误导致回滚的语句不计数；调用方自己开启的事务之后回滚时无法感知，仍然计数
+func registerWriteCounter(db *gorm.DB) error {
+	count := func(tx *gorm.DB) {
+		if tx.Error != nil || tx.DryRun || tx.Statement.SQL.Len() == 0 || tx.Statement.Table == "" {
+			return
+		}
+		writeCountsMu.Lock()
+		writeCounts[tx.Statement.Table]++
+		writeCountsMu.Unlock()
+	}
+
+	// SkipDefaultTransaction 时没有 gorm:commit_or_rollback_transaction，回调排在最后，同样在 hook 之后
+	const after = "gorm:commit_or_rollback_transaction"
+	if err := db.Callback().Create().After(after).Register("write_counter:after_create", count); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After(after).Register("write_counter:after_update", count); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After(after).Register("write_counter:after_delete", count)
+}
+
+func testMetrics(gormDb *gorm.DB) {
+	// 插件注册在 Config 上，同名插件只能注册一次，使用一个单独的 Config，不影响 main 中按配置注册的插件
+	db, err := standaloneDB(gormDb, &gorm.Config{})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 使用独立的 Registry，避免与 DefaultRegisterer 中已注册的指标冲突
+	registry := prometheus.NewRegistry()
+	plugin := newMetricsPlugin("test", time.Second, registry)
+	if err = db.Use(plugin); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer plugin.Close()
+	if _, ok := db.Config.Plugins["gorm:prometheus"]; !ok {
+		fmt.Println("plugin gorm:prometheus not registered")
+		return
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for _, family := range families {
+		// gorm_dbstats_in_use = 0
+		fmt.Printf("%s = %v\n", family.GetName(), family.GetMetric()[0].GetGauge().GetValue())
+	}
+}
+
+func testDBStats(gormDb *gorm.DB) {
+	if err := gormDb.Take(&User{}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 查询结束后连接回到连接池，仍然是打开的
+	stats, err := dbStats(gormDb)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if stats.OpenConnections < 1 {
+		fmt.Printf("expect at least 1 open connection, got %+v\n", stats)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	// db stats: open = 1, in use = 0, wait count = 0
+	logDBStats(ctx, gormDb, 100*time.Millisecond)
+}
+
+func testWriteCounter(gormDb *gorm.DB) {
+	// main 中已经在 gormDb 上调用了 registerWriteCounter，计数从启动开始累加，这里只比较前后的差值
+	before := WriteCounts()
+
+	// 批量创建只有一条语句
+	companies := []Company{{Name: "sharpe-write-1"}, {Name: "sharpe-write-2"}}
+	if err := gormDb.Create(&companies).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	company := Company{Name: "sharpe-write-3"}
+	if err := gormDb.Create(&company).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for _, c := range companies {
+		if err := gormDb.Model(&c).Update("name", c.Name+"-renamed").Error; err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
+	after := WriteCounts()
+	if got := after["t_companies"] - before["t_companies"]; got != 4 {
+		fmt.Printf("expect 4 writes to t_companies, got %d\n", got)
+		return
+	}
+	// map[t_companies:4 ...]
+	fmt.Printf("write counts = %v\n", after)
+
+	// 写入之后出错，默认事务回滚，不计数；在单独的 Config 上注册一个提交前出错的回调，模拟 AfterCreate 返回错误
+	failDb, err := standaloneDB(gormDb, &gorm.Config{})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = registerWriteCounter(failDb); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	errAfterCreate := errors.New("after create failed")
+	err = failDb.Callback().Create().Before("gorm:commit_or_rollback_transaction").Register("write_counter_demo:fail", func(tx *gorm.DB) {
+		tx.AddError(errAfterCreate)
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = failDb.Create(&Company{Name: "sharpe-write-rollback"}).Error; !errors.Is(err, errAfterCreate) {
+		fmt.Printf("expect the after create error, got %v\n", err)
+		return
+	}
+	if got := WriteCounts()["t_companies"] - after["t_companies"]; got != 0 {
+		fmt.Printf("expect rolled back writes not to be counted, got %d\n", got)
+		return
+	}
+}
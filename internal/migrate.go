@@ -0,0 +1,362 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// SchemaMigration 记录已经执行过的迁移，表名为 t_schema_migrations
+type SchemaMigration struct {
+	ID        string `gorm:"size:191;primaryKey"`
+	AppliedAt int64  `gorm:"autoCreateTime"`
+}
+
+// Migration 一次有版本号的迁移，ID 需要全局唯一，按切片中的顺序执行
+// Down 撤销 Up 的修改，为 nil 时不能回滚
+type Migration struct {
+	ID   string
+	Up   func(*gorm.DB) error
+	Down func(*gorm.DB) error
+}
+
+// appliedMigrations 查询已经执行的迁移 ID
+func appliedMigrations(tx *gorm.DB) (map[string]bool, error) {
+	var ids []string
+	if err := tx.Model(&SchemaMigration{}).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	done := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		done[id] = true
+	}
+	return done, nil
+}
+
+// runMigrations 跳过已经记录的迁移，在同一个事务中执行剩下的迁移并记录 ID
+// 注意 MySQL 的 DDL 会隐式提交事务，失败时已经执行的建表、加列等操作不会回滚，只有记录会回滚
+func runMigrations(db *gorm.DB, migs []Migration) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		done, err := appliedMigrations(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range migs {
+			if done[mig.ID] {
+				continue
+			}
+			if err := mig.Up(tx); err != nil {
+				return fmt.Errorf("migration %s: %w", mig.ID, err)
+			}
+			if err := tx.Create(&SchemaMigration{ID: mig.ID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rollbackLast 回滚最近一次执行的迁移，即 migs 中最后一个已经记录的迁移，没有已执行的迁移时什么都不做
+func rollbackLast(db *gorm.DB, migs []Migration) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		done, err := appliedMigrations(tx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migs) - 1; i >= 0; i-- {
+			mig := migs[i]
+			if !done[mig.ID] {
+				continue
+			}
+			if mig.Down == nil {
+				return fmt.Errorf("migration %s can not be rolled back", mig.ID)
+			}
+			if err := mig.Down(tx); err != nil {
+				return fmt.Errorf("rollback %s: %w", mig.ID, err)
+			}
+			return tx.Delete(&SchemaMigration{ID: mig.ID}).Error
+		}
+		return nil
+	})
+}
+
+//go:embed migrations/*.sql
+var sqlMigrations embed.FS
+
+// runSQLFiles 按文件名顺序执行 dir 下的 .sql 文件，文件名作为迁移 ID 记录在 t_schema_migrations 中，已经记录的文件不再执行
+// 每个文件按 ; 拆分成多条语句依次 Exec，字符串或注释中不能出现 ;，也不能定义存储过程
+// 与 runMigrations 一样，MySQL 的 DDL 会隐式提交事务，失败时只有记录会回滚
+func runSQLFiles(db *gorm.DB, fsys embed.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	if err = db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		done, err := appliedMigrations(tx)
+		if err != nil {
+			return err
+		}
+
+		// ReadDir 返回的结果已经按文件名排序
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || path.Ext(name) != ".sql" || done[name] {
+				continue
+			}
+			content, err := fsys.ReadFile(path.Join(dir, name))
+			if err != nil {
+				return err
+			}
+			for _, stmt := range strings.Split(string(content), ";") {
+				if strings.TrimSpace(stmt) == "" {
+					continue
+				}
+				if err = tx.Exec(stmt).Error; err != nil {
+					return fmt.Errorf("migration %s: %w", name, err)
+				}
+			}
+			if err = tx.Create(&SchemaMigration{ID: name}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// verifySchema 检查每个模型的字段在表中都有对应的列，返回的错误列出所有缺失的列，例如
+// missing columns: t_tags.extra, t_users.nickname
+func verifySchema(db *gorm.DB, models ...interface{}) error {
+	var missing []string
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		for _, field := range stmt.Schema.Fields {
+			// 关联等没有列的字段 DBName 为空
+			if field.DBName == "" {
+				continue
+			}
+			if !db.Migrator().HasColumn(model, field.DBName) {
+				missing = append(missing, stmt.Table+"."+field.DBName)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingColumns, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Tag 只用于演示迁移
+type Tag struct {
+	ID   uint
+	Name string `gorm:"size:64"`
+}
+
+// TagWithColor 加了 Color 列之后的 Tag
+type TagWithColor struct {
+	Tag
+	Color string `gorm:"size:16"`
+}
+
+func (TagWithColor) TableName() string {
+	return "t_tags"
+}
+
+func testMigrations(gormDb *gorm.DB) {
+	// 清理上一次演示留下的表和记录
+	if err := gormDb.Migrator().DropTable(&Tag{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.Migrator().AutoMigrate(&SchemaMigration{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.Where("id LIKE ?", "demo_%").Delete(&SchemaMigration{}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	runs := 0
+	migs := []Migration{
+		{ID: "demo_001_create_tags", Up: func(tx *gorm.DB) error {
+			runs++
+			return tx.Migrator().CreateTable(&Tag{})
+		}},
+		{ID: "demo_002_seed_tags", Up: func(tx *gorm.DB) error {
+			runs++
+			return tx.Create(&[]Tag{{Name: "go"}, {Name: "gorm"}}).Error
+		}},
+	}
+
+	if err := runMigrations(gormDb, migs); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if runs != 2 {
+		fmt.Printf("expect 2 migrations to run, got %d\n", runs)
+		return
+	}
+	// 第二次执行时两个迁移都已经记录过了
+	if err := runMigrations(gormDb, migs); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if runs != 2 {
+		fmt.Printf("expect second run to be a no-op, got %d runs\n", runs)
+		return
+	}
+
+	var count int64
+	if err := gormDb.Model(&Tag{}).Count(&count).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if count != 2 {
+		fmt.Printf("expect 2 tags, got %d\n", count)
+		return
+	}
+}
+
+func testRollbackMigration(gormDb *gorm.DB) {
+	if err := gormDb.Migrator().DropTable(&Tag{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.Migrator().AutoMigrate(&SchemaMigration{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.Where("id LIKE ?", "demo_%").Delete(&SchemaMigration{}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	migs := []Migration{
+		{
+			ID:   "demo_001_create_tags",
+			Up:   func(tx *gorm.DB) error { return tx.Migrator().CreateTable(&Tag{}) },
+			Down: func(tx *gorm.DB) error { return tx.Migrator().DropTable(&Tag{}) },
+		},
+		{
+			// ALTER TABLE `t_tags` ADD `color` varchar(16)
+			ID:   "demo_003_add_tag_color",
+			Up:   func(tx *gorm.DB) error { return tx.Migrator().AddColumn(&TagWithColor{}, "Color") },
+			Down: func(tx *gorm.DB) error { return tx.Migrator().DropColumn(&TagWithColor{}, "Color") },
+		},
+	}
+	if err := runMigrations(gormDb, migs); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if !gormDb.Migrator().HasColumn(&TagWithColor{}, "Color") {
+		fmt.Println("expect column color to be added")
+		return
+	}
+
+	// 只回滚最后一个迁移，t_tags 表还在
+	if err := rollbackLast(gormDb, migs); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if gormDb.Migrator().HasColumn(&TagWithColor{}, "Color") || !gormDb.Migrator().HasTable(&Tag{}) {
+		fmt.Println("expect column color to be dropped and table t_tags to be kept")
+		return
+	}
+	var count int64
+	if err := gormDb.Model(&SchemaMigration{}).Where("id = ?", "demo_003_add_tag_color").Count(&count).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if count != 0 {
+		fmt.Println("expect version record to be removed")
+		return
+	}
+}
+
+func testVerifySchema(gormDb *gorm.DB) {
+	if err := verifySchema(gormDb, &User{}, &Company{}, &Profile{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// TagWithColor 多了 Color 字段，t_tags 只按 Tag 迁移
+	if err := gormDb.Migrator().DropTable(&Tag{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.AutoMigrate(&Tag{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	err := verifySchema(gormDb, &TagWithColor{})
+	if !errors.Is(err, ErrMissingColumns) || !strings.Contains(err.Error(), "t_tags.color") {
+		fmt.Printf("expect t_tags.color to be missing, got %v\n", err)
+		return
+	}
+	fmt.Println(err.Error())
+}
+
+func testSQLFiles(gormDb *gorm.DB) {
+	// 清理上一次演示留下的表和记录
+	if err := gormDb.Migrator().DropTable("t_notes"); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.Migrator().AutoMigrate(&SchemaMigration{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.Where("id LIKE ?", "demo_%").Delete(&SchemaMigration{}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// 第二次执行时两个文件都已经记录过了，INSERT 不会重复执行
+	for i := 0; i < 2; i++ {
+		if err := runSQLFiles(gormDb, sqlMigrations, "migrations"); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+
+	var count int64
+	if err := gormDb.Table("t_notes").Count(&count).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if count != 2 {
+		fmt.Printf("expect 2 notes, got %d\n", count)
+		return
+	}
+	var applied int64
+	err := gormDb.Model(&SchemaMigration{}).Where("id IN ?", []string{"demo_004_create_notes.sql", "demo_005_seed_notes.sql"}).Count(&applied).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if applied != 2 {
+		fmt.Printf("expect 2 files to be recorded, got %d\n", applied)
+		return
+	}
+}
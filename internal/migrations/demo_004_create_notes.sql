@@ -0,0 +1,6 @@
+-- 只用于演示 runSQLFiles
+CREATE TABLE IF NOT EXISTS `t_notes` (
+    `id`   bigint unsigned NOT NULL AUTO_INCREMENT,
+    `body` varchar(255)    NOT NULL,
+    PRIMARY KEY (`id`)
+);
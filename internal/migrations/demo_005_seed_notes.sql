@@ -0,0 +1,2 @@
+INSERT INTO `t_notes` (`body`) VALUES ('first');
+INSERT INTO `t_notes` (`body`) VALUES ('second');
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+)
+
+// listAfter 游标分页，返回 id 大于 afterID 的 limit 条记录，调用方用最后一条记录的 ID 作为下一页的游标
+// SELECT * FROM `t_users` WHERE id > 100 AND `t_users`.`is_deleted` = 0 ORDER BY id asc LIMIT 10
+// 与 Limit(10).Offset(10000) 相比：OFFSET 需要先扫描并丢弃前 10000 行，页数越靠后越慢；
+// 而 id > ? 直接走主键索引定位，每一页的代价都一样。翻页期间有插入或删除时 OFFSET 还会漏掉或重复记录
+func listAfter(gormDb *gorm.DB, afterID uint, limit int) ([]User, error) {
+	var users []User
+	err := gormDb.Where("id > ?", afterID).Order("id asc").Limit(limit).Find(&users).Error
+	return users, err
+}
+
+func testKeysetPagination(gormDb *gorm.DB) {
+	var allUsers []User
+	result := gormDb.Order("id asc").Find(&allUsers)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	var (
+		cursor  uint
+		visited []uint
+		seen    = make(map[uint]bool)
+	)
+	for {
+		page, err := listAfter(gormDb, cursor, 3)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, user := range page {
+			if seen[user.ID] {
+				fmt.Printf("user %d is duplicated\n", user.ID)
+				return
+			}
+			seen[user.ID] = true
+			visited = append(visited, user.ID)
+		}
+		cursor = page[len(page)-1].ID
+	}
+
+	if len(visited) != len(allUsers) {
+		fmt.Printf("expect %d users, visited %d\n", len(allUsers), len(visited))
+		return
+	}
+	for i, user := range allUsers {
+		if visited[i] != user.ID {
+			fmt.Printf("expect user %d at %d, got %d\n", user.ID, i, visited[i])
+			return
+		}
+	}
+	fmt.Printf("visited %d users\n", len(visited))
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/plugin/soft_delete"
+)
+
+// Profile 用户资料，属于 User
+type Profile struct {
+	ID     uint
+	UserID uint `gorm:"uniqueIndex"`
+	Bio    string
+	// 已有的数据加列时需要 default:0，否则为 NULL，is_deleted = 0 的条件查不到
+	IsDeleted soft_delete.DeletedAt `gorm:"not null;default:0"`
+}
+
+// findUsersWithoutProfile 反连接：LEFT JOIN 之后右表为 NULL 的就是没有资料的用户
+// 手写的 JOIN 不会加上 Profile 的软删除条件，需要写在 ON 中
+// SELECT `t_users`.`id`,... FROM `t_users` LEFT JOIN t_profiles ON t_profiles.user_id = t_users.id AND t_profiles.is_deleted = 0 WHERE t_profiles.id IS NULL AND `t_users`.`is_deleted` = 0
+func findUsersWithoutProfile(gormDb *gorm.DB) ([]User, error) {
+	var users []User
+	err := gormDb.Joins("LEFT JOIN t_profiles ON t_profiles.user_id = t_users.id AND t_profiles.is_deleted = 0").
+		Where("t_profiles.id IS NULL").Find(&users).Error
+	return users, err
+}
+
+// findUsersOfCompany 关联名作为 Joins 的参数时会生成 LEFT JOIN 并同时查询关联的字段，
+// 传入的 *gorm.DB 的条件会加到 ON 上，而不是 WHERE 上
+// SELECT `t_users`.`id`,...,`Company`.`id` AS `Company__id`,`Company`.`name` AS `Company__name` FROM `t_users`
+// LEFT JOIN `t_companies` `Company` ON `t_users`.`company_id` = `Company`.`id` AND `Company`.`name` = 'Acme'
+func findUsersOfCompany(gormDb *gorm.DB, name string) ([]User, error) {
+	var users []User
+	err := gormDb.Joins("Company", gormDb.Where(&Company{Name: name})).
+		Where("`Company`.`id` IS NOT NULL").Find(&users).Error
+	return users, err
+}
+
+func testJoins(gormDb *gorm.DB) {
+	withProfile := User{Name: "sharpe-with-profile", Profile: &Profile{Bio: "hello"}}
+	withoutProfile := User{Name: "sharpe-without-profile"}
+	// 创建 User 时会一同创建 Profile
+	result := gormDb.Create([]*User{&withProfile, &withoutProfile})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	users, err := findUsersWithoutProfile(gormDb)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	found := false
+	for _, user := range users {
+		if user.ID == withProfile.ID {
+			fmt.Printf("user %d has a profile, should not be returned\n", user.ID)
+			return
+		}
+		found = found || user.ID == withoutProfile.ID
+	}
+	if !found {
+		fmt.Printf("expect user %d without profile to be returned\n", withoutProfile.ID)
+		return
+	}
+	fmt.Printf("users without profile len = %d\n", len(users))
+
+	company := Company{Name: "Acme"}
+	if err = gormDb.Create(&company).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = gormDb.Model(&withoutProfile).Update("company_id", company.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	acmeUsers, err := findUsersOfCompany(gormDb, "Acme")
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for _, user := range acmeUsers {
+		// Joins 会把关联的字段一起填充
+		if user.Company == nil || user.Company.Name != "Acme" {
+			fmt.Printf("expect company Acme, got %+v\n", user.Company)
+			return
+		}
+	}
+	fmt.Printf("acmeUsers len = %d\n", len(acmeUsers))
+}
+
+// profileCompletionStats 统计有资料和没有资料的用户数，LEFT JOIN 后 COUNT(t_profiles.id) 不会计算 NULL
+// SELECT COUNT(t_profiles.id) AS with_profile,COUNT(*) - COUNT(t_profiles.id) AS without_profile FROM `t_users`
+// LEFT JOIN t_profiles ON t_profiles.user_id = t_users.id AND t_profiles.is_deleted = 0 WHERE `t_users`.`is_deleted` = 0
+func profileCompletionStats(gormDb *gorm.DB) (withProfile, withoutProfile int64, err error) {
+	var stats struct {
+		WithProfile    int64
+		WithoutProfile int64
+	}
+	err = gormDb.Model(&User{}).
+		Select("COUNT(t_profiles.id) AS with_profile", "COUNT(*) - COUNT(t_profiles.id) AS without_profile").
+		Joins("LEFT JOIN t_profiles ON t_profiles.user_id = t_users.id AND t_profiles.is_deleted = 0").
+		Scan(&stats).Error
+	return stats.WithProfile, stats.WithoutProfile, err
+}
+
+func testProfileCompletionStats(gormDb *gorm.DB) {
+	withBefore, withoutBefore, err := profileCompletionStats(gormDb)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	users := []User{
+		{Name: "sharpe-stats-1", Profile: &Profile{Bio: "one"}},
+		{Name: "sharpe-stats-2", Profile: &Profile{Bio: "two"}},
+		{Name: "sharpe-stats-3"},
+		{Name: "sharpe-stats-4"},
+		{Name: "sharpe-stats-5"},
+	}
+	if err = gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	withAfter, withoutAfter, err := profileCompletionStats(gormDb)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if withAfter-withBefore != 2 || withoutAfter-withoutBefore != 3 {
+		fmt.Printf("expect +2 with profile and +3 without, got +%d and +%d\n", withAfter-withBefore, withoutAfter-withoutBefore)
+		return
+	}
+	fmt.Printf("withProfile = %d, withoutProfile = %d\n", withAfter, withoutAfter)
+}
+
+func testCascadeSoftDelete(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-cascade", Profile: &Profile{Bio: "cascade"}}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// 传入带 ID 的 user，AfterDelete 才知道要删除谁的 Profile
+	if err := gormDb.Delete(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	err := gormDb.Where("user_id = ?", user.ID).First(&Profile{}).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("expect profile to be soft deleted, got %v\n", err)
+		return
+	}
+
+	// 软删除的 Profile 仍然在表中，把 is_deleted 改回 0 就恢复了
+	if err = gormDb.Unscoped().Model(&User{}).Where("id = ?", user.ID).Update("is_deleted", 0).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = gormDb.Unscoped().Model(&Profile{}).Where("user_id = ?", user.ID).Update("is_deleted", 0).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	restored := new(User)
+	if err = gormDb.Preload("Profile").First(restored, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if restored.Profile == nil || restored.Profile.Bio != "cascade" {
+		fmt.Printf("expect restored profile, got %+v\n", restored.Profile)
+		return
+	}
+}
+
+func testNPlusOne(gormDb *gorm.DB) {
+	users := []User{
+		{Name: "sharpe-n-plus-one-1", Profile: &Profile{Bio: "one"}},
+		{Name: "sharpe-n-plus-one-2", Profile: &Profile{Bio: "two"}},
+		{Name: "sharpe-n-plus-one-3", Profile: &Profile{Bio: "three"}},
+	}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	ids := []uint{users[0].ID, users[1].ID, users[2].ID}
+
+	// 先查用户，再逐个加载 Profile：1 + 3 条 SQL
+	// SELECT * FROM `t_users` WHERE id IN (1,2,3) AND `t_users`.`is_deleted` = 0
+	// SELECT * FROM `t_profiles` WHERE `t_profiles`.`user_id` = 1 AND `t_profiles`.`is_deleted` = 0
+	// ...
+	perUser := countQueries(gormDb, func(db *gorm.DB) {
+		var found []User
+		if err := db.Where("id IN ?", ids).Find(&found).Error; err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		for i := range found {
+			profile := new(Profile)
+			if err := db.Model(&found[i]).Association("Profile").Find(profile); err != nil {
+				fmt.Println(err.Error())
+				return
+			}
+			found[i].Profile = profile
+		}
+	})
+	if perUser != len(ids)+1 {
+		fmt.Printf("expect %d queries loading profiles one by one, got %d\n", len(ids)+1, perUser)
+		return
+	}
+
+	// Preload 用 IN 一次查出所有 Profile：2 条 SQL，与用户数量无关
+	// SELECT * FROM `t_profiles` WHERE `t_profiles`.`user_id` IN (1,2,3) AND `t_profiles`.`is_deleted` = 0
+	preloaded := countQueries(gormDb, func(db *gorm.DB) {
+		if err := db.Preload("Profile").Where("id IN ?", ids).Find(&[]User{}).Error; err != nil {
+			fmt.Println(err.Error())
+		}
+	})
+	if preloaded != 2 {
+		fmt.Printf("expect 2 queries with Preload, got %d\n", preloaded)
+		return
+	}
+	fmt.Printf("one by one = %d queries, preload = %d queries\n", perUser, preloaded)
+}
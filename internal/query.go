@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// listAsMap 按主键批量查询并以主键为 key 返回，重复的 id 只查询一次，不存在的 id 不会出现在结果中
+func listAsMap(gormDb *gorm.DB, ids []uint) (map[uint]User, error) {
+	seen := make(map[uint]bool, len(ids))
+	uniqueIDs := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			uniqueIDs = append(uniqueIDs, id)
+		}
+	}
+
+	users := make(map[uint]User, len(uniqueIDs))
+	if len(uniqueIDs) == 0 {
+		return users, nil
+	}
+	var found []User
+	// SELECT * FROM `t_users` WHERE `t_users`.`id` IN (1,2,3) AND `t_users`.`is_deleted` = 0
+	if err := gormDb.Find(&found, uniqueIDs).Error; err != nil {
+		return nil, err
+	}
+	for _, user := range found {
+		users[user.ID] = user
+	}
+	return users, nil
+}
+
+// userExists 判断是否存在满足条件的用户，只查询常量 1，不加载整行
+// Find 查不到记录时不会返回 ErrRecordNotFound，用 RowsAffected 判断
+// SELECT 1 FROM `t_users` WHERE name = 'sharpe' AND `t_users`.`is_deleted` = 0 LIMIT 1
+func userExists(db *gorm.DB, conds ...interface{}) (bool, error) {
+	tx := db.Model(&User{}).Select("1")
+	if len(conds) > 0 {
+		tx = tx.Where(conds[0], conds[1:]...)
+	}
+	var one int
+	result := tx.Limit(1).Find(&one)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// streamUsers 逐行读取用户并交给 fn，内存中同一时间只有一行，fn 返回错误时停止读取并返回该错误
+// Rows 返回的是 *sql.Rows，需要自己 Close，否则连接不会还回连接池
+func streamUsers(db *gorm.DB, fn func(User) error) error {
+	rows, err := db.Model(&User{}).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		if err = db.ScanRows(rows, &user); err != nil {
+			return err
+		}
+		if err = fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ageStats 一条语句计算年龄的最小值、最大值和平均值，可以先用 Where 限定范围
+// 没有匹配的记录时聚合函数返回 NULL，这里返回零值而不是错误
+// SELECT MIN(age) AS min_age,MAX(age) AS max_age,AVG(age) AS avg_age FROM `t_users` WHERE `t_users`.`is_deleted` = 0
+func ageStats(db *gorm.DB) (min, max uint8, avg float64, err error) {
+	var stats struct {
+		MinAge sql.NullInt64
+		MaxAge sql.NullInt64
+		AvgAge sql.NullFloat64
+	}
+	err = db.Model(&User{}).Select("MIN(age) AS min_age", "MAX(age) AS max_age", "AVG(age) AS avg_age").Scan(&stats).Error
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return uint8(stats.MinAge.Int64), uint8(stats.MaxAge.Int64), stats.AvgAge.Float64, nil
+}
+
+// scanInto 执行 query 构造的查询并把结果扫描到 []T 中，T 的字段按列名(蛇形)匹配，用于临时的统计报表
+// query 需要自己指定 Model 或 Table，没有匹配的行时返回空切片
+func scanInto[T any](db *gorm.DB, query func(*gorm.DB) *gorm.DB) ([]T, error) {
+	rows := make([]T, 0)
+	if err := query(db).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// findByIDs 按主键批量查询，ids 为空时直接返回空切片，不执行查询
+// 空切片交给 IN 时生成的是 IN (NULL)，这里不依赖这种行为
+// SELECT * FROM `t_users` WHERE `t_users`.`id` IN (1,2) AND `t_users`.`is_deleted` = 0
+func findByIDs(db *gorm.DB, ids []uint) ([]User, error) {
+	users := make([]User, 0, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+	err := db.Find(&users, ids).Error
+	return users, err
+}
+
+func testListAsMap(gormDb *gorm.DB) {
+	created := []User{{Name: "sharpe-map-1"}, {Name: "sharpe-map-2"}}
+	if err := gormDb.Create(&created).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	var maxID uint
+	if err := gormDb.Unscoped().Model(&User{}).Select("MAX(id)").Scan(&maxID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	missingID := maxID + 1000
+	users, err := listAsMap(gormDb, []uint{created[0].ID, created[1].ID, created[0].ID, missingID})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(users) != 2 || users[created[0].ID].Name != "sharpe-map-1" || users[created[1].ID].Name != "sharpe-map-2" {
+		fmt.Printf("unexpected users %+v\n", users)
+		return
+	}
+	// 不存在的 id 没有对应的 key，而不是返回错误
+	if _, ok := users[missingID]; ok {
+		fmt.Printf("expect no entry for missing id %d\n", missingID)
+		return
+	}
+}
+
+func testUserExists(gormDb *gorm.DB) {
+	sql := explainSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&User{}).Select("1").Where("name = ?", "sharpe").Limit(1).Find(new(int))
+	})
+	if !strings.HasPrefix(sql, "SELECT 1 FROM") || !strings.HasSuffix(sql, "LIMIT 1") {
+		fmt.Printf("expect SELECT 1 ... LIMIT 1, got %s\n", sql)
+		return
+	}
+
+	user := User{Name: "sharpe-exists"}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	exists, err := userExists(gormDb, "name = ?", "sharpe-exists")
+	if err != nil || !exists {
+		fmt.Printf("expect user to exist, got %v, %v\n", exists, err)
+		return
+	}
+	// 不存在时返回 false，不会把 ErrRecordNotFound 透传给调用方
+	exists, err = userExists(gormDb, &User{Name: "sharpe-not-exists"})
+	if err != nil || exists {
+		fmt.Printf("expect user not to exist, got %v, %v\n", exists, err)
+		return
+	}
+}
+
+func testStreamUsers(gormDb *gorm.DB) {
+	var count int64
+	if err := gormDb.Model(&User{}).Count(&count).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	visited := make(map[uint]int)
+	err := streamUsers(gormDb, func(user User) error {
+		visited[user.ID]++
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if int64(len(visited)) != count {
+		fmt.Printf("expect %d users visited, got %d\n", count, len(visited))
+		return
+	}
+	for id, n := range visited {
+		if n != 1 {
+			fmt.Printf("expect user %d to be visited once, got %d\n", id, n)
+			return
+		}
+	}
+
+	// fn 返回错误时提前结束
+	errStop := errors.New("stop")
+	n := 0
+	err = streamUsers(gormDb, func(user User) error {
+		n++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || n != 1 {
+		fmt.Printf("expect to stop after the first user, got %d, %v\n", n, err)
+		return
+	}
+}
+
+func testAgeStats(gormDb *gorm.DB) {
+	users := []User{{Name: "sharpe-age-1", Age: 18}, {Name: "sharpe-age-2", Age: 30}, {Name: "sharpe-age-3", Age: 45}}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	ids := []uint{users[0].ID, users[1].ID, users[2].ID}
+
+	min, max, avg, err := ageStats(gormDb.Where("id IN ?", ids))
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if min != 18 || max != 45 || avg != 31 {
+		fmt.Printf("expect 18, 45, 31, got %d, %d, %v\n", min, max, avg)
+		return
+	}
+
+	// 没有匹配的记录
+	min, max, avg, err = ageStats(gormDb.Where("1 = 0"))
+	if err != nil || min != 0 || max != 0 || avg != 0 {
+		fmt.Printf("expect zero values for no rows, got %d, %d, %v, %v\n", min, max, avg, err)
+		return
+	}
+}
+
+func testFindByIDs(gormDb *gorm.DB) {
+	countDb, counter, err := countingSession(gormDb)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	created := []User{{Name: "sharpe-ids-1"}, {Name: "sharpe-ids-2"}}
+	if err = countDb.Create(&created).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	users, err := findByIDs(countDb, nil)
+	if queries := counter.Count("query"); err != nil || users == nil || len(users) != 0 || queries != 0 {
+		fmt.Printf("expect an empty slice without query, got %v, %d queries, %v\n", users, queries, err)
+		return
+	}
+	users, err = findByIDs(countDb, []uint{created[0].ID})
+	if queries := counter.Count("query"); err != nil || len(users) != 1 || users[0].ID != created[0].ID || queries != 1 {
+		fmt.Printf("expect user %d, got %v, %d queries, %v\n", created[0].ID, users, queries, err)
+		return
+	}
+	users, err = findByIDs(countDb, []uint{created[0].ID, created[1].ID})
+	if queries := counter.Count("query"); err != nil || len(users) != 2 || queries != 2 {
+		fmt.Printf("expect 2 users, got %v, %d queries, %v\n", users, queries, err)
+		return
+	}
+}
+
+func testScanInto(gormDb *gorm.DB) {
+	users := []User{
+		{Name: "sharpe-histogram-1", Age: 18},
+		{Name: "sharpe-histogram-2", Age: 18},
+		{Name: "sharpe-histogram-3", Age: 30},
+	}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	ids := []uint{users[0].ID, users[1].ID, users[2].ID}
+
+	// SELECT age, COUNT(*) AS count FROM `t_users` WHERE id IN (1,2,3) AND `t_users`.`is_deleted` = 0 GROUP BY `age` ORDER BY age
+	histogram, err := scanInto[struct {
+		Age   uint8
+		Count int64
+	}](gormDb, func(db *gorm.DB) *gorm.DB {
+		return db.Model(&User{}).Select("age, COUNT(*) AS count").Where("id IN ?", ids).Group("age").Order("age")
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(histogram) != 2 || histogram[0].Age != 18 || histogram[0].Count != 2 || histogram[1].Age != 30 || histogram[1].Count != 1 {
+		fmt.Printf("expect [{18 2} {30 1}], got %v\n", histogram)
+		return
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// session 返回一个新的 Session，之后的链式调用都会复制 Statement，不会修改 db 本身
+// db.Where(...) 返回的 *gorm.DB 继续调用 Where 时会在原来的 Statement 上追加条件，用它查询两次时前一次的条件会带到后一次
+func session(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{})
+}
+
+// Repository 通用的增删改查，T 为任意 GORM 模型
+type Repository[T any] struct {
+	db *gorm.DB
+}
+
+func NewRepository[T any](db *gorm.DB) *Repository[T] {
+	return &Repository[T]{db: db}
+}
+
+func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	return translateError(session(r.db).WithContext(ctx).Create(entity).Error)
+}
+
+// GetByID 使用 Take 而不是 First：First/Last 依赖 model 的主键排序，
+// 配合 Table 且目标不是结构体(例如 map)时无法排序，Take 则没有这个限制
+// id 可以是整数也可以是字符串主键，作为参数传递而不是拼接到 SQL 中
+func (r *Repository[T]) GetByID(ctx context.Context, id any) (*T, error) {
+	entity := new(T)
+	if err := session(r.db).WithContext(ctx).Where("id = ?", id).Take(entity).Error; err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+func (r *Repository[T]) List(ctx context.Context) ([]T, error) {
+	var entities []T
+	err := session(r.db).WithContext(ctx).Find(&entities).Error
+	return entities, err
+}
+
+// Update 更新 entity 的指定字段，entity 需要包含主键，返回更新的行数
+func (r *Repository[T]) Update(ctx context.Context, entity *T, values map[string]interface{}) (int64, error) {
+	result := session(r.db).WithContext(ctx).Model(entity).Updates(values)
+	return result.RowsAffected, translateError(result.Error)
+}
+
+// Delete 根据主键删除，模型支持软删除时执行软删除，返回删除的行数
+func (r *Repository[T]) Delete(ctx context.Context, id any) (int64, error) {
+	result := session(r.db).WithContext(ctx).Where("id = ?", id).Delete(new(T))
+	return result.RowsAffected, translateError(result.Error)
+}
+
+// UserRepository 在 Repository[User] 的基础上增加 User 特有的方法
+type UserRepository struct {
+	Repository[User]
+}
+
+func NewUserRepository(db *gorm.DB) *UserRepository {
+	return &UserRepository{Repository[User]{db: db}}
+}
+
+// model 返回一个作用于 User 的新 Session，每次调用都从干净的 Statement 开始，前一次查询的条件不会带到下一次
+func (r *UserRepository) model() *gorm.DB {
+	return session(r.db).Model(&User{})
+}
+
+// Find 按主键查询，记录不存在时返回 found = false 和 nil 错误，只有查询失败时才返回错误
+// 调用方不需要再用 errors.Is(err, gorm.ErrRecordNotFound) 区分
+func (r *UserRepository) Find(ctx context.Context, id uint) (user *User, found bool, err error) {
+	user, err = r.GetByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// Delete 先查询再按记录删除，BeforeDelete 才能看到 IsAdmin 并拒绝删除管理员，记录不存在时返回 0
+func (r *UserRepository) Delete(ctx context.Context, id any) (int64, error) {
+	user, err := r.GetByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	result := session(r.db).WithContext(ctx).Delete(user)
+	return result.RowsAffected, translateError(result.Error)
+}
+
+// defaultPageSize Page 的 size 小于 1 时每页的条数
+const defaultPageSize = 20
+
+// Page 按主键顺序分页查询，page 从 1 开始，page 小于 1 时按第 1 页查询，size 小于 1 时使用 defaultPageSize
+// SELECT * FROM `t_users` WHERE `t_users`.`is_deleted` = 0 ORDER BY id LIMIT 20 OFFSET 20
+func (r *UserRepository) Page(ctx context.Context, page, size int) ([]User, error) {
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = defaultPageSize
+	}
+	var users []User
+	err := r.model().WithContext(ctx).Order("id").Offset((page - 1) * size).Limit(size).Find(&users).Error
+	return users, err
+}
+
+// WithTable 返回一个使用指定表名的副本，用于按租户或按年份分表的场景，例如 t_users_2024
+// Table 返回的 *gorm.DB 不能直接复用，否则前一次调用的条件会带到下一次，所以需要再开一个新的 Session
+func (r *UserRepository) WithTable(name string) *UserRepository {
+	return NewUserRepository(session(r.db.Table(name)))
+}
+
+// Restore 恢复被软删除的用户，没有匹配的已删除记录时返回 gorm.ErrRecordNotFound
+// IsDeleted 为 0 表示未删除，软删除时写入删除时间，Unscoped 才能匹配到已删除的记录
+// 删除后 Email 已被其他用户注册时违反 idx_email_deleted，返回 ErrEmailExists
+func (r *UserRepository) Restore(ctx context.Context, id uint) error {
+	// UPDATE `t_users` SET `is_deleted`=0,`update_on`=1641373000 WHERE id = 1 AND is_deleted <> 0
+	result := r.model().WithContext(ctx).Unscoped().Where("id = ? AND is_deleted <> 0", id).Update("is_deleted", 0)
+	if result.Error != nil {
+		return translateUserError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Upsert 主键为 0 时创建并回填主键，否则用 Save 更新所有字段，包括零值字段
+// 创建时调用 BeforeSave、BeforeCreate，更新时调用 BeforeSave、BeforeUpdate、AfterUpdate
+// Save 会把结构体中的 CreatedAt 等字段原样写回，u 需要是完整查询出来的记录，而不是只设置了部分字段的结构体
+// UPDATE `t_users` SET `name`='sharpe',`email`=NULL,`age`=0,...,`created_at`=1641373000,... WHERE `t_users`.`is_deleted` = 0 AND `id` = 1
+func (r *UserRepository) Upsert(ctx context.Context, u *User) error {
+	if u.ID == 0 {
+		return r.Create(ctx, u)
+	}
+	return translateError(session(r.db).WithContext(ctx).Save(u).Error)
+}
+
+// UpdateName 名字有变化时才更新，返回是否执行了更新，名字相同时不会执行 UPDATE，也不会调用 BeforeUpdate、AfterUpdate
+// 先查询是为了在名字没有变化时跳过 UPDATE 和它的 hook，直接 UPDATE 时 MySQL 返回的行数为 0，无法区分没有变化和记录不存在
+// SELECT * FROM `t_users` WHERE id = 1 AND `t_users`.`is_deleted` = 0 LIMIT 1
+// UPDATE `t_users` SET `name`='sharpe',`update_on`=1641373000 WHERE `t_users`.`is_deleted` = 0 AND `id` = 1
+func (r *UserRepository) UpdateName(ctx context.Context, id uint, name string) (changed bool, err error) {
+	user, err := r.GetByID(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if user.Name == name {
+		return false, nil
+	}
+	if _, err = r.Update(ctx, user, map[string]interface{}{"name": name}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func testRepositoryWithTable(gormDb *gorm.DB) {
+	ctx := context.Background()
+	// 分表与 t_users 结构相同，CREATE TABLE ... LIKE 会复制列和索引，但不会复制外键
+	tables := []string{"t_users_2024", "t_users_2025"}
+	for _, table := range tables {
+		result := gormDb.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` LIKE `t_users`", table))
+		if result.Error != nil {
+			fmt.Println(result.Error.Error())
+			return
+		}
+	}
+
+	repo := NewUserRepository(gormDb)
+	for _, table := range tables {
+		tableRepo := repo.WithTable(table)
+		// INSERT INTO `t_users_2024` (...) VALUES (...)
+		user := User{Name: "sharpe-" + table}
+		if err := tableRepo.Create(ctx, &user); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+
+		// SELECT * FROM `t_users_2024` WHERE id = 1 AND `t_users_2024`.`is_deleted` = 0 LIMIT 1
+		takeUser, err := tableRepo.GetByID(ctx, user.ID)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		if takeUser.Name != user.Name {
+			fmt.Printf("expect %s in %s, got %s\n", user.Name, table, takeUser.Name)
+			return
+		}
+
+		users, err := tableRepo.List(ctx)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		// 每张表只能看到自己的数据
+		for _, u := range users {
+			if u.Name != user.Name {
+				fmt.Printf("unexpected user %s in %s\n", u.Name, table)
+				return
+			}
+		}
+		fmt.Printf("%s users len = %d\n", table, len(users))
+	}
+}
+
+func testRestore(gormDb *gorm.DB) {
+	ctx := context.Background()
+	repo := NewUserRepository(gormDb)
+	user := User{Name: "sharpe-restore"}
+	if err := repo.Create(ctx, &user); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if _, err := deleteUser(gormDb, user.ID); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 软删除后普通查询看不到
+	if _, err := repo.GetByID(ctx, user.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("expect ErrRecordNotFound after delete, got %v\n", err)
+		return
+	}
+
+	if err := repo.Restore(ctx, user.ID); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	restored, err := repo.GetByID(ctx, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("restored = %+v\n", restored)
+
+	// 没有被删除的记录无法恢复
+	if err = repo.Restore(ctx, user.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("expect ErrRecordNotFound for a live user, got %v\n", err)
+		return
+	}
+
+	// 删除后 Email 被其他用户注册，恢复时违反唯一索引
+	email := uniqueEmail("sharpe-restore")
+	deleted := User{Name: "sharpe-restore-email-1", Email: &email}
+	if err = repo.Create(ctx, &deleted); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if _, err = deleteUser(gormDb, deleted.ID); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = repo.Create(ctx, &User{Name: "sharpe-restore-email-2", Email: &email}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = repo.Restore(ctx, deleted.ID); !errors.Is(err, ErrEmailExists) {
+		fmt.Printf("expect ErrEmailExists, got %v\n", err)
+		return
+	}
+}
+
+func testGenericRepository(gormDb *gorm.DB) {
+	ctx := context.Background()
+
+	users := NewRepository[User](gormDb)
+	user := User{Name: "sharpe-generic"}
+	if err := users.Create(ctx, &user); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if _, err := users.Update(ctx, &user, map[string]interface{}{"age": 50}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	gotUser, err := users.GetByID(ctx, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if gotUser.Age != 50 {
+		fmt.Printf("expect age = 50, got %d\n", gotUser.Age)
+		return
+	}
+	if rows, err := users.Delete(ctx, user.ID); err != nil || rows != 1 {
+		fmt.Printf("expect 1 user deleted, got %d, %v\n", rows, err)
+		return
+	}
+
+	// 同一套代码用于 Company
+	companies := NewRepository[Company](gormDb)
+	company := Company{Name: "Generic Inc"}
+	if err = companies.Create(ctx, &company); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if _, err = companies.Update(ctx, &company, map[string]interface{}{"name": "Generic Ltd"}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	gotCompany, err := companies.GetByID(ctx, company.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if gotCompany.Name != "Generic Ltd" {
+		fmt.Printf("expect name = Generic Ltd, got %s\n", gotCompany.Name)
+		return
+	}
+	allCompanies, err := companies.List(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("companies len = %d\n", len(allCompanies))
+	// Company 没有软删除字段，执行的是 DELETE
+	if rows, err := companies.Delete(ctx, company.ID); err != nil || rows != 1 {
+		fmt.Printf("expect 1 company deleted, got %d, %v\n", rows, err)
+		return
+	}
+	if _, err = companies.GetByID(ctx, company.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("expect ErrRecordNotFound, got %v\n", err)
+		return
+	}
+}
+
+func testSession(gormDb *gorm.DB) {
+	dryRun := gormDb.Session(&gorm.Session{DryRun: true})
+	findByName := func(base *gorm.DB, name string) string {
+		stmt := base.Where("name = ?", name).Find(&[]User{}).Statement
+		return gormDb.Dialector.Explain(stmt.SQL.String(), stmt.Vars...)
+	}
+
+	// base 不是新的 Session，第一次查询的条件和生成的 SQL 都留在了 base 的 Statement 上，
+	// 第二次查询实际执行的仍然是 name = 'a'
+	base := dryRun.Where("age > ?", 18)
+	findByName(base, "a")
+	if sql := findByName(base, "b"); !strings.Contains(sql, "name = 'a'") {
+		fmt.Printf("expect the first condition to leak without session, got %s\n", sql)
+		return
+	}
+
+	base = session(dryRun.Where("age > ?", 18))
+	findByName(base, "a")
+	// SELECT * FROM `t_users` WHERE age > 18 AND name = 'b' AND `t_users`.`is_deleted` = 0
+	sql := findByName(base, "b")
+	if strings.Contains(sql, "name = 'a'") || !strings.Contains(sql, "age > 18 AND name = 'b'") {
+		fmt.Printf("expect only age and name = b, got %s\n", sql)
+		return
+	}
+	fmt.Printf("sql = %s\n", sql)
+
+	// 传给 Repository 的 db 带有条件时，多次调用之间也不会互相影响
+	repo := NewUserRepository(gormDb.Where("age > ?", 0))
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := repo.List(ctx); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+	}
+}
+
+func testRepositoryModel(gormDb *gorm.DB) {
+	repo := NewUserRepository(gormDb.Session(&gorm.Session{DryRun: true}).Where("age > ?", 18))
+	findByName := func(name string) string {
+		stmt := repo.model().Where("name = ?", name).Find(&[]User{}).Statement
+		return gormDb.Dialector.Explain(stmt.SQL.String(), stmt.Vars...)
+	}
+
+	findByName("a")
+	// SELECT * FROM `t_users` WHERE age > 18 AND name = 'b' AND `t_users`.`is_deleted` = 0
+	sql := findByName("b")
+	if strings.Contains(sql, "name = 'a'") || !strings.Contains(sql, "age > 18 AND name = 'b'") {
+		fmt.Printf("expect only age and name = b, got %s\n", sql)
+		return
+	}
+}
+
+func testUpsert(gormDb *gorm.DB) {
+	ctx := context.Background()
+	repo := NewUserRepository(gormDb)
+
+	user := User{Name: "sharpe-upsert-repo", Age: 30}
+	if err := repo.Upsert(ctx, &user); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if user.ID == 0 {
+		fmt.Println("expect ID to be populated after create")
+		return
+	}
+
+	var before, after int64
+	if err := gormDb.Model(&User{}).Count(&before).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	loaded, err := repo.GetByID(ctx, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// Save 会写入零值，Age 被更新为 0
+	loaded.Age = 0
+	loaded.Name = "sharpe-upsert-repo-saved"
+	if err = repo.Upsert(ctx, loaded); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = gormDb.Model(&User{}).Count(&after).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	saved, err := repo.GetByID(ctx, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 更新分支不会插入新的记录
+	if after != before || saved.Age != 0 || saved.Name != "sharpe-upsert-repo-saved" || saved.CreatedAt != user.CreatedAt {
+		fmt.Printf("expect the same row to be updated, got %d -> %d users, %+v\n", before, after, saved)
+		return
+	}
+}
+
+func testRepositoryFind(gormDb *gorm.DB) {
+	ctx := context.Background()
+	repo := NewUserRepository(gormDb)
+	user := User{Name: "sharpe-find"}
+	if err := repo.Create(ctx, &user); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	found, ok, err := repo.Find(ctx, user.ID)
+	if err != nil || !ok || found.Name != user.Name {
+		fmt.Printf("expect user %d to be found, got %+v, %v, %v\n", user.ID, found, ok, err)
+		return
+	}
+
+	// 不存在不是错误
+	found, ok, err = repo.Find(ctx, user.ID+1000000)
+	if err != nil || ok || found != nil {
+		fmt.Printf("expect not found without error, got %+v, %v, %v\n", found, ok, err)
+		return
+	}
+
+	// 查询失败时返回错误
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, ok, err = repo.Find(cancelled, user.ID); !errors.Is(err, context.Canceled) || ok {
+		fmt.Printf("expect context.Canceled, got %v, %v\n", ok, err)
+		return
+	}
+}
+
+func testUpdateName(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-update-name"}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// 查询和更新各一条，Repository 会用传入的 ctx 替换 Session 的 ctx，所以传入 db.Statement.Context 才能被统计
+	var changed bool
+	var err error
+	statements := countQueries(gormDb, func(db *gorm.DB) {
+		changed, err = NewUserRepository(db).UpdateName(db.Statement.Context, user.ID, "sharpe-update-name-renamed")
+	})
+	if err != nil || !changed || statements != 2 {
+		fmt.Printf("expect a change with 2 statements, got %v, %d statements, %v\n", changed, statements, err)
+		return
+	}
+
+	// 名字相同，只有查询
+	statements = countQueries(gormDb, func(db *gorm.DB) {
+		changed, err = NewUserRepository(db).UpdateName(db.Statement.Context, user.ID, "sharpe-update-name-renamed")
+	})
+	if err != nil || changed || statements != 1 {
+		fmt.Printf("expect no change with 1 statement, got %v, %d statements, %v\n", changed, statements, err)
+		return
+	}
+
+	renamed := new(User)
+	if err = gormDb.First(renamed, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if renamed.Name != "sharpe-update-name-renamed" {
+		fmt.Printf("expect name sharpe-update-name-renamed, got %s\n", renamed.Name)
+		return
+	}
+}
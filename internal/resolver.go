@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// useReadReplicas 读写分离 https://gorm.io/zh_CN/docs/dbresolver.html
+// 没有配置 Sources 时 db 本身就是写库，Find/First 等查询会随机路由到 replicas，Create/Update/Delete 以及事务中的语句都在写库执行
+// 可以用 Clauses(dbresolver.Write) 或 Clauses(dbresolver.Read) 强制指定
+func useReadReplicas(db *gorm.DB, replicaDSNs []string) error {
+	if len(replicaDSNs) == 0 {
+		return nil
+	}
+	replicas := make([]gorm.Dialector, 0, len(replicaDSNs))
+	for _, dsn := range replicaDSNs {
+		replicas = append(replicas, mysql.Open(dsn))
+	}
+	return db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: replicas,
+		Policy:   dbresolver.RandomPolicy{},
+	}))
+}
+
+// DbConfig:
+//
+//	ReplicaDSNs:
+//	  - "user:pass@tcp(replica1:3306)/gorm101?charset=utf8mb4&parseTime=True&loc=Local"
+//	  - "user:pass@tcp(replica2:3306)/gorm101?charset=utf8mb4&parseTime=True&loc=Local"
+func testReadReplicas(gormDb *gorm.DB) {
+	replicaDSNs := viper.GetStringSlice("DbConfig.ReplicaDSNs")
+	if len(replicaDSNs) == 0 {
+		fmt.Println("DbConfig.ReplicaDSNs is not configured")
+		return
+	}
+
+	// main 中已经按同样的配置在 gormDb 上调用了 useReadReplicas
+	// 写入一定在写库
+	user := User{Name: "sharpe-resolver"}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 刚写入的数据直接从写库读，不受主从延迟影响
+	if err := gormDb.Clauses(dbresolver.Write).First(&User{}, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// 强制从从库读，从库还没有同步时会返回 ErrRecordNotFound
+	err := gormDb.Clauses(dbresolver.Read).First(&User{}, user.ID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("user %d is not replicated yet\n", user.ID)
+		return
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("user %d is read from replica\n", user.ID)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+	"math"
+	"net"
+)
+
+// userService 通过 UserRepository 实现 user_grpc.pb.go 中的 UserServiceServer
+type userService struct {
+	UnimplementedUserServiceServer
+	repo *UserRepository
+}
+
+func toUserReply(user User) *UserReply {
+	return &UserReply{Id: uint64(user.ID), Name: user.Name, Age: uint32(user.Age)}
+}
+
+func (s *userService) GetUser(ctx context.Context, in *GetUserRequest) (*UserReply, error) {
+	user, err := s.repo.GetByID(ctx, uint(in.Id))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toUserReply(*user), nil
+}
+
+func (s *userService) ListUsers(ctx context.Context, in *ListUsersRequest) (*ListUsersReply, error) {
+	if in.Page < 1 {
+		in.Page = 1
+	}
+	if in.Size < 1 || in.Size > 100 {
+		in.Size = defaultPageSize
+	}
+	users, err := s.repo.Page(ctx, int(in.Page), int(in.Size))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	reply := &ListUsersReply{Users: make([]*UserReply, 0, len(users))}
+	for _, user := range users {
+		reply.Users = append(reply.Users, toUserReply(user))
+	}
+	return reply, nil
+}
+
+func (s *userService) CreateUser(ctx context.Context, in *CreateUserRequest) (*UserReply, error) {
+	if in.Name == "" {
+		return nil, toStatus(ErrEmptyName)
+	}
+	if in.Age > math.MaxUint8 {
+		return nil, status.Error(codes.InvalidArgument, "age out of range")
+	}
+	user := User{Name: in.Name, Email: in.Email, Age: uint8(in.Age)}
+	if err := s.repo.Create(ctx, &user); err != nil {
+		return nil, toStatus(err)
+	}
+	return toUserReply(user), nil
+}
+
+// toStatus 与 HTTP 接口的 writeError 对应，把包内的错误映射为 gRPC 状态码
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, ErrDuplicate):
+		return status.Error(codes.AlreadyExists, "user already exists")
+	case errors.Is(err, ErrEmptyName), errors.Is(err, ErrInvalidEmail), errors.Is(err, ErrDataTooLong):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	fmt.Println(err.Error())
+	return status.Error(codes.Internal, "internal error")
+}
+
+// requestIDInterceptor 从 metadata 的 x-request-id 中读取请求 ID，没有时生成一个，
+// 放到 ctx 中传给 Repository，这次请求执行的 SQL 在日志中都会带上这个 ID
+func requestIDInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := uuid.NewString()
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("x-request-id")) > 0 {
+		id = md.Get("x-request-id")[0]
+	}
+	return handler(WithRequestID(ctx, id), req)
+}
+
+func newUserServer(repo *UserRepository) *grpc.Server {
+	s := grpc.NewServer(grpc.UnaryInterceptor(requestIDInterceptor))
+	RegisterUserServiceServer(s, &userService{repo: repo})
+	return s
+}
+
+func testUserService(gormDb *gorm.DB) {
+	// bufconn 在内存中模拟网络连接，不需要监听端口
+	lis := bufconn.Listen(1 << 20)
+	server := newUserServer(NewUserRepository(gormDb))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer conn.Close()
+	client := NewUserServiceClient(conn)
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", "rpc-42")
+
+	created, err := client.CreateUser(ctx, &CreateUserRequest{Name: "sharpe-rpc", Age: 28})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	got, err := client.GetUser(ctx, &GetUserRequest{Id: created.Id})
+	if err != nil || !proto.Equal(got, created) {
+		fmt.Printf("expect %v, got %v, %v\n", created, got, err)
+		return
+	}
+	list, err := client.ListUsers(ctx, &ListUsersRequest{Page: 1, Size: 2})
+	if err != nil || len(list.Users) != 2 {
+		fmt.Printf("expect 2 users, got %v, %v\n", list, err)
+		return
+	}
+
+	_, err = client.GetUser(ctx, &GetUserRequest{Id: created.Id + 1000000})
+	if status.Code(err) != codes.NotFound {
+		fmt.Printf("expect NotFound, got %v\n", err)
+		return
+	}
+	email := fmt.Sprintf("sharpe-rpc-%s@gmail.com", uuid.NewString())
+	if _, err = client.CreateUser(ctx, &CreateUserRequest{Name: "sharpe-rpc-1", Email: &email}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	_, err = client.CreateUser(ctx, &CreateUserRequest{Name: "sharpe-rpc-2", Email: &email})
+	if status.Code(err) != codes.AlreadyExists {
+		fmt.Printf("expect AlreadyExists, got %v\n", err)
+		return
+	}
+}
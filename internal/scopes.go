@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// Scopes 允许复用通用的查询逻辑 https://gorm.io/zh_CN/docs/scopes.html
+// scope 的签名是 func(*gorm.DB) *gorm.DB
+
+// ActiveUsers 状态正常的用户
+func ActiveUsers(db *gorm.DB) *gorm.DB {
+	return db.Where("status = ?", StatusActive)
+}
+
+// OlderThan 年龄大于 age 的用户，带参数的 scope 返回一个闭包
+func OlderThan(age uint8) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("age > ?", age)
+	}
+}
+
+// OrderByNewest 按创建时间倒序
+func OrderByNewest(db *gorm.DB) *gorm.DB {
+	return db.Order("created_at desc")
+}
+
+// Adults 成年用户
+func Adults(db *gorm.DB) *gorm.DB {
+	return db.Where("age >= ?", 18)
+}
+
+// RecentUsers 最近 7 天创建的用户，CreatedAt 是秒级时间戳
+func RecentUsers(db *gorm.DB) *gorm.DB {
+	return db.Where("created_at >= ?", time.Now().AddDate(0, 0, -7).Unix())
+}
+
+// createdBetween 创建时间在 [from, to] 之间，CreatedAt 是秒级时间戳，边界需要转换成 Unix 秒，
+// 直接传 time.Time 会被格式化成 '2022-01-05 10:00:00' 与整数比较，结果不正确
+// SELECT * FROM `t_users` WHERE created_at BETWEEN 1641340800 AND 1641427199 AND `t_users`.`is_deleted` = 0
+func createdBetween(db *gorm.DB, from, to time.Time) *gorm.DB {
+	return db.Where("created_at BETWEEN ? AND ?", from.Unix(), to.Unix())
+}
+
+// namedScopes 按名字注册的 scope，接口传入的字符串只能选择这里的 scope，不会拼进 SQL
+var namedScopes = map[string]func(*gorm.DB) *gorm.DB{
+	"active": ActiveUsers,
+	"recent": RecentUsers,
+	"adults": Adults,
+}
+
+// applyScopes 按名字依次应用 scope，有未注册的名字时返回 ErrUnknownScope
+func applyScopes(db *gorm.DB, names ...string) (*gorm.DB, error) {
+	scopes := make([]func(*gorm.DB) *gorm.DB, 0, len(names))
+	for _, name := range names {
+		scope, ok := namedScopes[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownScope, name)
+		}
+		scopes = append(scopes, scope)
+	}
+	return db.Scopes(scopes...), nil
+}
+
+func testScopes(gormDb *gorm.DB) {
+	var users []User
+	// SELECT * FROM `t_users` WHERE status = 1 AND age > 18 AND `t_users`.`is_deleted` = 0 ORDER BY created_at desc
+	result := gormDb.Scopes(ActiveUsers, OlderThan(18), OrderByNewest).Find(&users)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	fmt.Printf("users len = %d, sql = %s\n", len(users), explainSQL(gormDb, func(tx *gorm.DB) *gorm.DB {
+		return tx.Scopes(ActiveUsers, OlderThan(18), OrderByNewest).Find(&[]User{})
+	}))
+
+	// DryRun 模式只生成 SQL 不执行
+	stmt := gormDb.Session(&gorm.Session{DryRun: true}).Scopes(ActiveUsers, OlderThan(18)).Find(&users).Statement
+	sql := stmt.SQL.String()
+	for _, want := range []string{"status = ?", "age > ?", " AND "} {
+		if !strings.Contains(sql, want) {
+			fmt.Printf("expect %q in %s\n", want, sql)
+			return
+		}
+	}
+	// sql = SELECT * FROM `t_users` WHERE status = ? AND age > ? AND `t_users`.`is_deleted` = ?, vars = [active 18 0]
+	fmt.Printf("sql = %s, vars = %v\n", sql, stmt.Vars)
+}
+
+func testNamedScopes(gormDb *gorm.DB) {
+	tx, err := applyScopes(gormDb.Session(&gorm.Session{DryRun: true}), "adults", "recent")
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// SELECT * FROM `t_users` WHERE age >= ? AND created_at >= ? AND `t_users`.`is_deleted` = ?
+	sql := tx.Find(&[]User{}).Statement.SQL.String()
+	if !strings.Contains(sql, "age >= ? AND created_at >= ?") {
+		fmt.Printf("expect adults and recent in order, got %s\n", sql)
+		return
+	}
+	fmt.Printf("sql = %s\n", sql)
+
+	if _, err := applyScopes(gormDb, "adults", "deleted"); !errors.Is(err, ErrUnknownScope) {
+		fmt.Printf("expect ErrUnknownScope, got %v\n", err)
+		return
+	}
+}
+
+func testCreatedBetween(gormDb *gorm.DB) {
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	// CreatedAt 不为零值时不会被自动填充
+	users := []User{
+		{Name: "sharpe-between-before", CreatedAt: day.Add(-time.Second).Unix()},
+		{Name: "sharpe-between-start", CreatedAt: day.Unix()},
+		{Name: "sharpe-between-noon", CreatedAt: day.Add(12 * time.Hour).Unix()},
+		{Name: "sharpe-between-after", CreatedAt: day.AddDate(0, 0, 1).Unix()},
+	}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	ids := []uint{users[0].ID, users[1].ID, users[2].ID, users[3].ID}
+
+	var found []User
+	err := createdBetween(gormDb, day, day.AddDate(0, 0, 1).Add(-time.Second)).Where("id IN ?", ids).Order("id").Find(&found).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// BETWEEN 包含两端
+	if len(found) != 2 || found[0].ID != users[1].ID || found[1].ID != users[2].ID {
+		fmt.Printf("expect start and noon users, got %+v\n", found)
+		return
+	}
+}
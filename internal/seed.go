@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+)
+
+// seedUsers 表为空时插入 n 个示例用户，表中已有数据(包括已软删除的)时什么也不做，可以重复执行
+func seedUsers(gormDb *gorm.DB, n int) error {
+	var count int64
+	if err := gormDb.Unscoped().Model(&User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	users := make([]User, 0, n)
+	for i := 1; i <= n; i++ {
+		users = append(users, User{
+			Name: fmt.Sprintf("sharpe-seed-%d", i),
+			Age:  uint8(16 + i%50),
+		})
+	}
+	return gormDb.CreateInBatches(&users, 100).Error
+}
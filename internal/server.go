@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userHandler 基于 UserRepository 的 JSON 接口
+// GET    /users?page=1&size=20 分页查询
+// GET    /users/{id}           按主键查询，不存在时返回 404
+// POST   /users                创建，参数不合法时返回 400，Email 重复时返回 409
+// PUT    /users/{id}           更新请求中出现的字段
+// DELETE /users/{id}           删除，成功时返回 204，管理员不能删除，返回 403
+type userHandler struct {
+	repo *UserRepository
+}
+
+func newUserHandler(repo *UserRepository) http.Handler {
+	h := &userHandler{repo: repo}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", h.users)
+	mux.HandleFunc("/users/", h.user)
+	return mux
+}
+
+func (h *userHandler) users(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.list(w, r)
+	case http.MethodPost:
+		h.create(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *userHandler) user(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/users/"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid user id"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r, uint(id))
+	case http.MethodPut:
+		h.update(w, r, uint(id))
+	case http.MethodDelete:
+		h.delete(w, r, uint(id))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// list page 从 1 开始，size 默认 20，最大 100
+func (h *userHandler) list(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+	if size < 1 || size > 100 {
+		size = defaultPageSize
+	}
+
+	users, err := h.repo.Page(r.Context(), page, size)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	dtos := make([]UserDTO, 0, len(users))
+	for _, user := range users {
+		dtos = append(dtos, toUserDTO(user))
+	}
+	writeJSON(w, http.StatusOK, dtos)
+}
+
+func (h *userHandler) get(w http.ResponseWriter, r *http.Request, id uint) {
+	user, err := h.repo.GetByID(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toUserDTO(*user))
+}
+
+// userRequest 创建和更新时接受的字段，不直接解码到 User，避免调用方设置 ID、IsAdmin 等字段
+// 更新时没有传或为 null 的字段不修改；Email 传空字符串表示清空，写入 NULL
+type userRequest struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+	Age   *uint8  `json:"age"`
+}
+
+// validate 只检查请求本身，Name 不能为空；Email 的格式由 BeforeSave 校验，创建和更新时都会返回 ErrInvalidEmail
+func (req userRequest) validate() error {
+	if req.Name != nil && *req.Name == "" {
+		return ErrEmptyName
+	}
+	return nil
+}
+
+func (h *userHandler) decode(w http.ResponseWriter, r *http.Request) (userRequest, bool) {
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return req, false
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, err)
+		return req, false
+	}
+	return req, true
+}
+
+func (h *userHandler) create(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+	if req.Name == nil {
+		writeError(w, ErrEmptyName)
+		return
+	}
+	user := User{Name: *req.Name, Email: req.Email}
+	if req.Age != nil {
+		user.Age = *req.Age
+	}
+	if err := h.repo.Create(r.Context(), &user); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, toUserDTO(user))
+}
+
+func (h *userHandler) update(w http.ResponseWriter, r *http.Request, id uint) {
+	req, ok := h.decode(w, r)
+	if !ok {
+		return
+	}
+	user, err := h.repo.GetByID(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	values := make(map[string]interface{})
+	if req.Name != nil {
+		values["name"] = *req.Name
+	}
+	if req.Email != nil {
+		// 空字符串写入 NULL 而不是 ''，Email 的唯一索引允许多个 NULL，BeforeSave 也不会校验 nil
+		if *req.Email == "" {
+			values["email"] = nil
+		} else {
+			values["email"] = *req.Email
+		}
+	}
+	if req.Age != nil {
+		values["age"] = *req.Age
+	}
+	if len(values) > 0 {
+		if _, err = h.repo.Update(r.Context(), user, values); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	// Updates 会把 map 中的值写回 user
+	writeJSON(w, http.StatusOK, toUserDTO(*user))
+}
+
+func (h *userHandler) delete(w http.ResponseWriter, r *http.Request, id uint) {
+	rows, err := h.repo.Delete(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if rows == 0 {
+		writeError(w, gorm.ErrRecordNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+// writeError 把错误映射为状态码，参数错误返回错误信息，其余错误返回 500，不把内部错误的细节返回给调用方
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+		return
+	case errors.Is(err, ErrEmptyName), errors.Is(err, ErrInvalidEmail), errors.Is(err, ErrDataTooLong):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, ErrDuplicate):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "user already exists"})
+		return
+	case errors.Is(err, ErrCannotDeleteAdmin):
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "can not delete admin user"})
+		return
+	}
+	fmt.Println(err.Error())
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+}
+
+// serveUsers 启动 HTTP 服务，监听 Server.Addr，默认 :8080
+// go run ./internal -demo serve
+func serveUsers(gormDb *gorm.DB) {
+	addr := viper.GetString("Server.Addr")
+	if addr == "" {
+		addr = ":8080"
+	}
+	fmt.Printf("listening on %s\n", addr)
+	if err := http.ListenAndServe(addr, newUserHandler(NewUserRepository(gormDb))); err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+func testUserHandler(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-http", Age: 33}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	handler := newUserHandler(NewUserRepository(gormDb))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/users/%d", user.ID), nil))
+	var dto UserDTO
+	if err := json.Unmarshal(rec.Body.Bytes(), &dto); rec.Code != http.StatusOK || err != nil || dto != toUserDTO(user) {
+		fmt.Printf("expect 200 with %+v, got %d %s\n", toUserDTO(user), rec.Code, rec.Body.String())
+		return
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/users/%d", user.ID+1000000), nil))
+	if rec.Code != http.StatusNotFound {
+		fmt.Printf("expect 404 for a missing user, got %d %s\n", rec.Code, rec.Body.String())
+		return
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users?page=1&size=2", nil))
+	var dtos []UserDTO
+	if err := json.Unmarshal(rec.Body.Bytes(), &dtos); rec.Code != http.StatusOK || err != nil || len(dtos) != 2 {
+		fmt.Printf("expect 200 with 2 users, got %d %s\n", rec.Code, rec.Body.String())
+		return
+	}
+	fmt.Println(rec.Body.String())
+}
+
+func testUserWriteHandler(gormDb *gorm.DB) {
+	handler := newUserHandler(NewUserRepository(gormDb))
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	email := fmt.Sprintf("sharpe-http-%d@gmail.com", time.Now().UnixNano())
+	rec := do(http.MethodPost, "/users", fmt.Sprintf(`{"name":"sharpe-http-post","email":%q,"age":25}`, email))
+	var created UserDTO
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); rec.Code != http.StatusCreated || err != nil || created.ID == 0 {
+		fmt.Printf("expect 201, got %d %s\n", rec.Code, rec.Body.String())
+		return
+	}
+
+	// 参数错误和 Email 重复
+	for _, c := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodPost, "/users", `{"name":"sharpe-http-bad","email":"not-an-email"}`, http.StatusBadRequest},
+		{http.MethodPost, "/users", `{"email":"sharpe-http-no-name@gmail.com"}`, http.StatusBadRequest},
+		{http.MethodPost, "/users", `{"name":"sharpe-http-bad","age":300}`, http.StatusBadRequest},
+		{http.MethodPost, "/users", fmt.Sprintf(`{"name":"sharpe-http-dup","email":%q}`, email), http.StatusConflict},
+		{http.MethodPut, fmt.Sprintf("/users/%d", created.ID), `{"name":""}`, http.StatusBadRequest},
+		{http.MethodPut, "/users/0", `{"age":30}`, http.StatusNotFound},
+	} {
+		if rec = do(c.method, c.target, c.body); rec.Code != c.code {
+			fmt.Printf("%s %s %s: expect %d, got %d %s\n", c.method, c.target, c.body, c.code, rec.Code, rec.Body.String())
+			return
+		}
+	}
+
+	rec = do(http.MethodPut, fmt.Sprintf("/users/%d", created.ID), `{"age":26}`)
+	var updated UserDTO
+	if err := json.Unmarshal(rec.Body.Bytes(), &updated); rec.Code != http.StatusOK || err != nil || updated.Age != 26 {
+		fmt.Printf("expect 200 with age 26, got %d %s\n", rec.Code, rec.Body.String())
+		return
+	}
+
+	// 没有传 email 时不修改，传空字符串时清空
+	reloaded := new(User)
+	if err := gormDb.First(reloaded, created.ID).Error; err != nil || reloaded.Email == nil || *reloaded.Email != email {
+		fmt.Printf("expect email %s to be kept, got %+v, %v\n", email, reloaded, err)
+		return
+	}
+	if rec = do(http.MethodPut, fmt.Sprintf("/users/%d", created.ID), `{"email":""}`); rec.Code != http.StatusOK {
+		fmt.Printf("expect 200 clearing email, got %d %s\n", rec.Code, rec.Body.String())
+		return
+	}
+	reloaded = new(User)
+	if err := gormDb.First(reloaded, created.ID).Error; err != nil || reloaded.Email != nil {
+		fmt.Printf("expect email to be cleared, got %+v, %v\n", reloaded, err)
+		return
+	}
+
+	if rec = do(http.MethodDelete, fmt.Sprintf("/users/%d", created.ID), ""); rec.Code != http.StatusNoContent {
+		fmt.Printf("expect 204, got %d %s\n", rec.Code, rec.Body.String())
+		return
+	}
+	if rec = do(http.MethodDelete, fmt.Sprintf("/users/%d", created.ID), ""); rec.Code != http.StatusNotFound {
+		fmt.Printf("expect 404 after delete, got %d %s\n", rec.Code, rec.Body.String())
+		return
+	}
+
+	admin := User{Name: "sharpe-http-admin", IsAdmin: true}
+	if err := gormDb.Create(&admin).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if rec = do(http.MethodDelete, fmt.Sprintf("/users/%d", admin.ID), ""); rec.Code != http.StatusForbidden {
+		fmt.Printf("expect 403 for admin, got %d %s\n", rec.Code, rec.Body.String())
+		return
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+// purgeInactiveBefore 软删除 cutoff 之前就没有更新过的用户，返回删除的行数
+// UpdateOn 保存的是秒级时间戳，需要用 cutoff.Unix() 比较，直接传 time.Time 会被格式化成日期字符串
+// UPDATE `t_users` SET `is_deleted`=1641373000 WHERE update_on < 1638781000 AND `t_users`.`is_deleted` = 0
+func purgeInactiveBefore(gormDb *gorm.DB, cutoff time.Time) (int64, error) {
+	result := gormDb.Where("update_on < ?", cutoff.Unix()).Delete(&User{})
+	return result.RowsAffected, result.Error
+}
+
+func testPurgeInactive(gormDb *gorm.DB) {
+	now := time.Now()
+	// 创建时 UpdateOn 不为零值时不会被自动填充
+	users := []User{
+		{Name: "sharpe-purge-stale-1", UpdateOn: now.AddDate(0, 0, -60).Unix()},
+		{Name: "sharpe-purge-stale-2", UpdateOn: now.AddDate(0, 0, -31).Unix()},
+		{Name: "sharpe-purge-fresh-1", UpdateOn: now.AddDate(0, 0, -29).Unix()},
+		{Name: "sharpe-purge-fresh-2", UpdateOn: now.Unix()},
+	}
+	result := gormDb.Create(&users)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	rows, err := purgeInactiveBefore(gormDb, now.AddDate(0, 0, -30))
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("purged %d users\n", rows)
+
+	var remaining []User
+	ids := []uint{users[0].ID, users[1].ID, users[2].ID, users[3].ID}
+	result = gormDb.Find(&remaining, ids)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	if len(remaining) != 2 || remaining[0].ID != users[2].ID || remaining[1].ID != users[3].ID {
+		fmt.Printf("expect only fresh users to remain, got %+v\n", remaining)
+		return
+	}
+}
+
+// hardDeleteExpired 物理删除软删除时间已经超过 olderThan 的用户，返回删除的行数
+// IsDeleted 的 tag 中的 "flag default:0" 没有用 `;` 分隔，实际没有开启 flag 模式，软删除时写入的是删除时的秒级时间戳，
+// 所以可以直接用 is_deleted 作为删除时间；Unscoped 下 Delete 执行的是 DELETE 而不是 UPDATE
+// DELETE FROM `t_users` WHERE is_deleted <> 0 AND is_deleted < 1641286600
+func hardDeleteExpired(gormDb *gorm.DB, olderThan time.Duration) (int64, error) {
+	result := gormDb.Unscoped().Where("is_deleted <> 0 AND is_deleted < ?", time.Now().Add(-olderThan).Unix()).Delete(&User{})
+	return result.RowsAffected, result.Error
+}
+
+func testHardDeleteExpired(gormDb *gorm.DB) {
+	users := []User{{Name: "sharpe-expired"}, {Name: "sharpe-recently-deleted"}}
+	result := gormDb.Create(&users)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	result = gormDb.Delete(&users)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	// 把第一条的删除时间改到 8 天前
+	result = gormDb.Unscoped().Model(&users[0]).UpdateColumn("is_deleted", time.Now().AddDate(0, 0, -8).Unix())
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	rows, err := hardDeleteExpired(gormDb, 7*24*time.Hour)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("hard deleted %d users\n", rows)
+
+	// Unscoped 也查不到已经物理删除的记录
+	var remaining []User
+	result = gormDb.Unscoped().Find(&remaining, []uint{users[0].ID, users[1].ID})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	if len(remaining) != 1 || remaining[0].ID != users[1].ID {
+		fmt.Printf("expect only the recently deleted user to remain, got %+v\n", remaining)
+		return
+	}
+}
+
+// userCounts 分别统计未删除和已软删除的用户数量
+// SELECT count(*) FROM `t_users` WHERE `t_users`.`is_deleted` = 0
+// SELECT count(*) FROM `t_users` WHERE is_deleted <> 0
+// 没有 deleted_at 列，软删除的记录 is_deleted 不为 0
+func userCounts(gormDb *gorm.DB) (live, deleted int64, err error) {
+	if err = gormDb.Model(&User{}).Count(&live).Error; err != nil {
+		return 0, 0, err
+	}
+	if err = gormDb.Unscoped().Model(&User{}).Where("is_deleted <> 0").Count(&deleted).Error; err != nil {
+		return 0, 0, err
+	}
+	return live, deleted, nil
+}
+
+func testUserCounts(gormDb *gorm.DB) {
+	liveBefore, deletedBefore, err := userCounts(gormDb)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	users := []User{{Name: "sharpe-count-1"}, {Name: "sharpe-count-2"}, {Name: "sharpe-count-3"}}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := gormDb.Delete(&User{}, []uint{users[0].ID, users[1].ID}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	live, deleted, err := userCounts(gormDb)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if live-liveBefore != 1 || deleted-deletedBefore != 2 {
+		fmt.Printf("expect 1 more live and 2 more deleted, got live %d -> %d, deleted %d -> %d\n", liveBefore, live, deletedBefore, deleted)
+		return
+	}
+	fmt.Printf("live = %d, deleted = %d\n", live, deleted)
+}
+
+// findIncludingDeleted 不论是否被软删除都能查到，只有记录不存在时才返回 gorm.ErrRecordNotFound
+// SELECT * FROM `t_users` WHERE `t_users`.`id` = 1 ORDER BY `t_users`.`id` LIMIT 1
+func findIncludingDeleted(gormDb *gorm.DB, id uint) (*User, error) {
+	user := new(User)
+	if err := gormDb.Unscoped().First(user, id).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func testFindIncludingDeleted(gormDb *gorm.DB) {
+	user := User{Name: "sharpe-including-deleted"}
+	if err := gormDb.Create(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if _, err := deleteUser(gormDb, user.ID); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if err := gormDb.First(&User{}, user.ID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("expect ErrRecordNotFound after delete, got %v\n", err)
+		return
+	}
+	deleted, err := findIncludingDeleted(gormDb, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if deleted.IsDeleted == 0 {
+		fmt.Printf("expect user %d to be marked as deleted\n", user.ID)
+		return
+	}
+
+	// 物理删除后才找不到
+	if err = gormDb.Unscoped().Delete(&User{}, user.ID).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if _, err = findIncludingDeleted(gormDb, user.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("expect ErrRecordNotFound after hard delete, got %v\n", err)
+		return
+	}
+}
+
+// defaultDeleteChunkSize deleteByIDs 的 chunkSize <= 0 时每批删除的数量
+const defaultDeleteChunkSize = 500
+
+// deleteByIDs 按主键分批软删除，每批一条语句，所有批次在同一个事务中，任何一批失败都会整体回滚，返回删除的总行数
+// 一次把上万个 id 放进 IN 可能超过 max_allowed_packet，分批可以控制每条语句的大小
+// UPDATE `t_users` SET `is_deleted`=1641373000 WHERE `t_users`.`id` IN (1,2,...,10) AND `t_users`.`is_deleted` = 0
+// 只按主键删除，与 BeforeDelete、AfterDelete 一样拦不住管理员，也不会级联删除 Profile
+func deleteByIDs(db *gorm.DB, ids []uint, chunkSize int) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultDeleteChunkSize
+	}
+
+	var total int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(ids); start += chunkSize {
+			end := start + chunkSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			result := tx.Delete(&User{}, ids[start:end])
+			if result.Error != nil {
+				return result.Error
+			}
+			total += result.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func testDeleteByIDs(gormDb *gorm.DB) {
+	// 统计删除语句的条数
+	countDb, counter, err := countingSession(gormDb)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if deleted, err := deleteByIDs(countDb, nil, 10); err != nil || deleted != 0 || counter.Count("delete") != 0 {
+		fmt.Printf("expect no statement for empty ids, got %d statements, %d deleted, %v\n", counter.Count("delete"), deleted, err)
+		return
+	}
+
+	users := make([]User, 25)
+	for i := range users {
+		users[i].Name = fmt.Sprintf("sharpe-delete-ids-%d", i)
+	}
+	if err = countDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	ids := make([]uint, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+
+	deleted, err := deleteByIDs(countDb, ids, 10)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if statements := counter.Count("delete"); deleted != 25 || statements != 3 {
+		fmt.Printf("expect 25 deleted in 3 statements, got %d deleted in %d statements\n", deleted, statements)
+		return
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"gorm.io/gorm"
+	"strconv"
+)
+
+// Status 用户状态，自定义数据类型需要实现 sql.Scanner 和 driver.Valuer 接口
+// https://gorm.io/zh_CN/docs/data_types.html
+type Status int
+
+const (
+	StatusActive Status = iota + 1
+	StatusBanned
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusActive:
+		return "active"
+	case StatusBanned:
+		return "banned"
+	}
+	return "Status(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Scan 实现 sql.Scanner 接口，把数据库中的整数读成 Status
+func (s *Status) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case int64:
+		*s = Status(v)
+	case []byte:
+		i, err := strconv.Atoi(string(v))
+		if err != nil {
+			return fmt.Errorf("scan status %q: %w", v, err)
+		}
+		*s = Status(i)
+	case nil:
+		*s = 0
+	default:
+		return fmt.Errorf("scan status: unsupported type %T", value)
+	}
+	return nil
+}
+
+// Value 实现 driver.Valuer 接口，写入数据库的是整数
+func (s Status) Value() (driver.Value, error) {
+	return int64(s), nil
+}
+
+// GormDataType 迁移时使用的通用数据类型，int 在 MySQL 中为 bigint
+func (Status) GormDataType() string {
+	return "int"
+}
+
+func testStatus(gormDb *gorm.DB) {
+	for _, status := range []Status{StatusActive, StatusBanned} {
+		user := User{Name: "sharpe-status-" + status.String(), Status: status}
+		result := gormDb.Create(&user)
+		if result.Error != nil {
+			fmt.Println(result.Error.Error())
+			return
+		}
+
+		statusUser := new(User)
+		result = gormDb.First(statusUser, user.ID)
+		if result.Error != nil {
+			fmt.Println(result.Error.Error())
+			return
+		}
+		if statusUser.Status != status {
+			fmt.Printf("expect status %v, got %v\n", status, statusUser.Status)
+			return
+		}
+	}
+
+	// 零值会使用默认值 StatusActive
+	defaultUser := User{Name: "sharpe-status-default"}
+	result := gormDb.Create(&defaultUser)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	// Value() 把 StatusActive 转成整数 1
+	// SELECT * FROM `t_users` WHERE status = 1 AND `t_users`.`is_deleted` = 0
+	var activeUsers []User
+	result = gormDb.Where("status = ?", StatusActive).Find(&activeUsers)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	for _, user := range activeUsers {
+		fmt.Printf("id = %d, status = %v\n", user.ID, user.Status)
+	}
+}
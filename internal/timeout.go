@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+// queryWithTimeout 为单次查询设置超时，fn 需要把传入的 ctx 交给 db.WithContext
+// 超时后驱动会中断查询并返回 context.DeadlineExceeded
+func queryWithTimeout[T any](ctx context.Context, d time.Duration, fn func(context.Context) (T, error)) (T, error) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	return fn(ctx)
+}
+
+func testQueryWithTimeout(gormDb *gorm.DB) {
+	ctx := context.Background()
+	users, err := queryWithTimeout(ctx, time.Second, func(ctx context.Context) ([]User, error) {
+		var users []User
+		err := gormDb.WithContext(ctx).Limit(10).Find(&users).Error
+		return users, err
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("users len = %d\n", len(users))
+
+	// SLEEP(2) 超过了 100ms 的超时时间
+	_, err = queryWithTimeout(ctx, 100*time.Millisecond, func(ctx context.Context) (int, error) {
+		var slept int
+		err := gormDb.WithContext(ctx).Raw("SELECT SLEEP(2)").Scan(&slept).Error
+		return slept, err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		fmt.Printf("expect context.DeadlineExceeded, got %v\n", err)
+		return
+	}
+	fmt.Println(err.Error())
+}
+
+// testContextCancel 确认 ctx 通过 WithContext 传到了驱动，去掉 Repository 中的 WithContext 后这里会失败
+func testContextCancel(gormDb *gorm.DB) {
+	repo := NewUserRepository(gormDb)
+
+	// 已经取消的 ctx，database/sql 在发送查询之前就会返回 context.Canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := repo.List(ctx); !errors.Is(err, context.Canceled) {
+		fmt.Printf("expect context.Canceled from List, got %v\n", err)
+		return
+	}
+	if _, err := repo.GetByID(ctx, 1); !errors.Is(err, context.Canceled) {
+		fmt.Printf("expect context.Canceled from GetByID, got %v\n", err)
+		return
+	}
+
+	// 查询过程中取消，驱动会中断正在执行的 SLEEP
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	var slept int
+	err := gormDb.WithContext(ctx).Raw("SELECT SLEEP(2)").Scan(&slept).Error
+	if !errors.Is(err, context.Canceled) {
+		fmt.Printf("expect context.Canceled during query, got %v\n", err)
+		return
+	}
+	fmt.Println(err.Error())
+}
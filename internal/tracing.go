@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const tracingSpanKey = "otel:span"
+
+// registerTracing 在 create/query/update/delete/row/raw 的执行前后注册回调，每条 SQL 对应一个 span
+// https://gorm.io/zh_CN/docs/write_plugins.html
+// span 的父级来自 db.WithContext 传入的 ctx，没有传入时是一个新的 trace
+func registerTracing(db *gorm.DB, tracer trace.Tracer) error {
+	before := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			ctx, span := tracer.Start(tx.Statement.Context, "gorm:"+operation, trace.WithSpanKind(trace.SpanKindClient))
+			tx.Statement.Context = ctx
+			tx.InstanceSet(tracingSpanKey, span)
+		}
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			value, ok := tx.InstanceGet(tracingSpanKey)
+			if !ok {
+				return
+			}
+			span := value.(trace.Span)
+			defer span.End()
+
+			span.SetAttributes(
+				attribute.String("db.system", "mysql"),
+				attribute.String("db.operation", operation),
+				attribute.String("db.sql.table", tx.Statement.Table),
+				attribute.String("db.statement", tx.Statement.SQL.String()),
+				attribute.Int64("db.rows_affected", tx.RowsAffected),
+			)
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				span.RecordError(tx.Error)
+				span.SetStatus(codes.Error, tx.Error.Error())
+			}
+		}
+	}
+
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Create().Before("gorm:create").Register("otel:before_create", before("create")),
+		callback.Create().After("gorm:create").Register("otel:after_create", after("create")),
+		callback.Query().Before("gorm:query").Register("otel:before_query", before("query")),
+		callback.Query().After("gorm:query").Register("otel:after_query", after("query")),
+		callback.Update().Before("gorm:update").Register("otel:before_update", before("update")),
+		callback.Update().After("gorm:update").Register("otel:after_update", after("update")),
+		callback.Delete().Before("gorm:delete").Register("otel:before_delete", before("delete")),
+		callback.Delete().After("gorm:delete").Register("otel:after_delete", after("delete")),
+		callback.Row().Before("gorm:row").Register("otel:before_row", before("row")),
+		callback.Row().After("gorm:row").Register("otel:after_row", after("row")),
+		callback.Raw().Before("gorm:raw").Register("otel:before_raw", before("raw")),
+		callback.Raw().After("gorm:raw").Register("otel:after_raw", after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testTracing(gormDb *gorm.DB) {
+	// SpanRecorder 记录所有结束的 span
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+
+	// 回调注册在 Config 上，会影响所有共享这个 Config 的 *gorm.DB，这里用一个单独的 Config
+	tracingDb, err := standaloneDB(gormDb, &gorm.Config{})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = registerTracing(tracingDb, provider.Tracer("gorm101")); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	var users []User
+	if err = tracingDb.Where("age > ?", 18).Find(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		fmt.Printf("expect 1 span, got %d\n", len(spans))
+		return
+	}
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[0].Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	if attributes["db.sql.table"].AsString() != "t_users" || attributes["db.operation"].AsString() != "query" {
+		fmt.Printf("unexpected span attributes %v\n", spans[0].Attributes())
+		return
+	}
+	// span gorm:query, db.statement = SELECT * FROM `t_users` WHERE age > ? AND `t_users`.`is_deleted` = ?
+	fmt.Printf("span %s, db.statement = %s\n", spans[0].Name(), attributes["db.statement"].AsString())
+}
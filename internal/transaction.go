@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// readOnly 在只读事务中执行 fn，fn 返回错误或 panic 时回滚，否则提交
+// START TRANSACTION READ ONLY
+// MySQL 拒绝只读事务中的写入，返回 1792 ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION，InnoDB 也不用为它分配事务 ID，开销更小；
+// SQLite 不支持只读事务，ReadOnly 会被忽略，写入照样成功
+func readOnly(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) (err error) {
+	tx := db.WithContext(ctx).Begin(&sql.TxOptions{ReadOnly: true})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			tx.Rollback()
+		}
+	}()
+	err = fn(tx)
+	panicked = false
+	if err != nil {
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func testReadOnly(gormDb *gorm.DB) {
+	ctx := context.Background()
+
+	// 只读事务中可以查询
+	var count int64
+	err := readOnly(ctx, gormDb, func(tx *gorm.DB) error {
+		return tx.Model(&User{}).Count(&count).Error
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	fmt.Printf("users count = %d\n", count)
+
+	user := User{Name: "sharpe-read-only"}
+	err = readOnly(ctx, gormDb, func(tx *gorm.DB) error {
+		return tx.Create(&user).Error
+	})
+	var mysqlErr *gomysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1792 {
+		fmt.Printf("expect error 1792 for a write in a read-only transaction, got %v\n", err)
+		return
+	}
+	// Error 1792: Cannot execute statement in a READ ONLY transaction.
+	fmt.Println(err.Error())
+
+	if err = gormDb.Where("name = ?", user.Name).Take(&User{}).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		fmt.Printf("expect user not to be created, got %v\n", err)
+		return
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/spf13/viper"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// truncateAll 清空 models 对应的表并重置自增主键，用于测试之间的隔离
+// 只能在名字中包含 test 的数据库上执行，避免误删开发或生产数据
+// MySQL 的 TRUNCATE TABLE 会重置自增值，但被外键引用的表不能 TRUNCATE，需要在同一个连接上临时关闭外键检查；
+// SQLite 没有 TRUNCATE，使用 DELETE FROM 并删除 sqlite_sequence 中的记录
+func truncateAll(db *gorm.DB, models ...interface{}) error {
+	name := db.Migrator().CurrentDatabase()
+	if !strings.Contains(strings.ToLower(name), "test") {
+		return fmt.Errorf("%w: %s", ErrNotTestDatabase, name)
+	}
+
+	tables := make([]string, 0, len(models))
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		tables = append(tables, stmt.Table)
+	}
+
+	if db.Dialector.Name() != "mysql" {
+		for _, table := range tables {
+			if err := db.Exec("DELETE FROM " + db.Statement.Quote(table)).Error; err != nil {
+				return err
+			}
+			if db.Dialector.Name() == "sqlite" {
+				if err := db.Exec("DELETE FROM sqlite_sequence WHERE name = ?", table).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	// SET FOREIGN_KEY_CHECKS 只对当前连接有效，从连接池中取出一个连接执行所有语句
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if _, err = conn.ExecContext(ctx, "TRUNCATE TABLE "+db.Statement.Quote(table)); err != nil {
+			break
+		}
+	}
+	// 连接会还回连接池，无论是否出错都要恢复外键检查
+	if _, resetErr := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1"); err == nil {
+		err = resetErr
+	}
+	return err
+}
+
+func testTruncateAll(gormDb *gorm.DB) {
+	cfg, err := gomysql.ParseDSN(viper.GetString("DbConfig.DSN"))
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 在同一个 MySQL 实例上用 dbName 打开一个新的连接池，用完需要关闭
+	open := func(dbName string) (*gorm.DB, func(), error) {
+		dbCfg := cfg.Clone()
+		dbCfg.DBName = dbName
+		db, err := gorm.Open(mysql.New(mysql.Config{DSN: dbCfg.FormatDSN()}), &gorm.Config{NamingStrategy: gormDb.NamingStrategy})
+		if err != nil {
+			return nil, nil, err
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, func() { sqlDB.Close() }, nil
+	}
+
+	// information_schema 一定存在，名字中也没有 test，不管配置的是哪个数据库都会被拒绝
+	schemaDb, closeSchemaDb, err := open("information_schema")
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer closeSchemaDb()
+	if err = truncateAll(schemaDb, &Tag{}); !errors.Is(err, ErrNotTestDatabase) {
+		fmt.Printf("expect ErrNotTestDatabase for information_schema, got %v\n", err)
+		return
+	}
+
+	// 使用 <数据库名>_test
+	testDBName := cfg.DBName + "_test"
+	if err = gormDb.Exec("CREATE DATABASE IF NOT EXISTS " + gormDb.Statement.Quote(testDBName)).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	testDb, closeTestDb, err := open(testDBName)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer closeTestDb()
+
+	if err = testDb.AutoMigrate(&Tag{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = testDb.Create(&[]Tag{{Name: "go"}, {Name: "gorm"}}).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err = truncateAll(testDb, &Tag{}); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	var count int64
+	if err = testDb.Model(&Tag{}).Count(&count).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 自增值被重置，新记录的主键从 1 开始
+	tag := Tag{Name: "go"}
+	if err = testDb.Create(&tag).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if count != 0 || tag.ID != 1 {
+		fmt.Printf("expect empty table and id 1, got count = %d, id = %d\n", count, tag.ID)
+		return
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MySQL 预处理语句最多支持 65535 个占位符
+const mysqlMaxPlaceholders = 65535
+
+// upsertUsersInBatches 分批插入用户，唯一键冲突时更新除主键、创建时间以外的所有字段
+// INSERT INTO `t_users` (...) VALUES (...),(...) ON DUPLICATE KEY UPDATE `name`=VALUES(`name`),`age`=VALUES(`age`),...
+// 每批的占位符数量为 batchSize * 列数，超过 MySQL 的限制时自动缩小 batchSize
+// 返回的行数遵循 MySQL 的约定：插入的行计 1，更新的行计 2，值没有变化的行计 0
+func upsertUsersInBatches(gormDb *gorm.DB, users []User, batchSize int) (int64, error) {
+	stmt := &gorm.Statement{DB: gormDb}
+	if err := stmt.Parse(&User{}); err != nil {
+		return 0, err
+	}
+	if maxBatchSize := mysqlMaxPlaceholders / len(stmt.Schema.DBNames); batchSize <= 0 || batchSize > maxBatchSize {
+		batchSize = maxBatchSize
+	}
+
+	result := gormDb.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(&users, batchSize)
+	return result.RowsAffected, result.Error
+}
+
+func testUpsertInBatches(gormDb *gorm.DB) {
+	emails := []string{uniqueEmail("sharpe-upsert-1"), uniqueEmail("sharpe-upsert-2"), uniqueEmail("sharpe-upsert-3")}
+	existing := []User{
+		{Name: "sharpe-upsert-1", Age: 18, Email: &emails[0]},
+		{Name: "sharpe-upsert-2", Age: 18, Email: &emails[1]},
+	}
+	result := gormDb.Create(&existing)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+
+	// 前两个与已有记录的 Email 冲突会被更新，第三个是新记录
+	users := []User{
+		{Name: "sharpe-upsert-1", Age: 30, Email: &emails[0]},
+		{Name: "sharpe-upsert-2", Age: 31, Email: &emails[1]},
+		{Name: "sharpe-upsert-3", Age: 32, Email: &emails[2]},
+	}
+	rows, err := upsertUsersInBatches(gormDb, users, 2)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	// 两行更新 + 一行插入
+	if rows != 5 {
+		fmt.Printf("expect 5 rows affected, got %d\n", rows)
+		return
+	}
+
+	var upserted []User
+	result = gormDb.Where("email IN ?", emails).Order("email").Find(&upserted)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	if len(upserted) != len(users) {
+		fmt.Printf("expect %d users, got %d\n", len(users), len(upserted))
+		return
+	}
+	for i, user := range upserted {
+		if user.Age != users[i].Age {
+			fmt.Printf("expect %s age = %d, got %d\n", *user.Email, users[i].Age, user.Age)
+			return
+		}
+	}
+	// 更新的记录保留原来的主键
+	if upserted[0].ID != existing[0].ID {
+		fmt.Printf("expect id = %d, got %d\n", existing[0].ID, upserted[0].ID)
+		return
+	}
+	fmt.Printf("upserted = %+v\n", upserted)
+}
+
+// bulkUpdateAges 用一条 UPDATE 给多个用户设置不同的年龄，避免逐条更新的多次往返
+// UPDATE `t_users` SET `age`=CASE id WHEN 1 THEN 20 WHEN 2 THEN 30 END,`update_on`=... WHERE id IN (1,2) AND `t_users`.`is_deleted` = 0
+// CASE id WHEN ... THEN ... END 是 MySQL 的简单 CASE 表达式，WHERE 限定了 id，所以不需要 ELSE
+func bulkUpdateAges(gormDb *gorm.DB, updates map[uint]uint8) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(updates))
+	for id := range updates {
+		ids = append(ids, id)
+	}
+	// map 的遍历顺序是随机的，排序后生成的 SQL 是稳定的
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var sql strings.Builder
+	args := make([]interface{}, 0, len(ids)*2)
+	sql.WriteString("CASE id")
+	for _, id := range ids {
+		sql.WriteString(" WHEN ? THEN ?")
+		args = append(args, id, updates[id])
+	}
+	sql.WriteString(" END")
+
+	return gormDb.Model(&User{}).Where("id IN ?", ids).Update("age", gorm.Expr(sql.String(), args...)).Error
+}
+
+func testBulkUpdateAges(gormDb *gorm.DB) {
+	users := make([]User, 5)
+	for i := range users {
+		users[i] = User{Name: fmt.Sprintf("sharpe-bulk-%d", i), Age: 18}
+	}
+	if err := gormDb.Create(&users).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	ids := make([]uint, 0, len(users))
+	updates := make(map[uint]uint8, len(users))
+	for i, user := range users {
+		ids = append(ids, user.ID)
+		updates[user.ID] = uint8(20 + i)
+	}
+	if err := bulkUpdateAges(gormDb, updates); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	var updated []User
+	if err := gormDb.Find(&updated, ids).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if len(updated) != len(users) {
+		fmt.Printf("expect %d users, got %d\n", len(users), len(updated))
+		return
+	}
+	for _, user := range updated {
+		if user.Age != updates[user.ID] {
+			fmt.Printf("expect user %d age %d, got %d\n", user.ID, updates[user.ID], user.Age)
+			return
+		}
+	}
+}
+
+// insertIgnore 插入用户，唯一键冲突的行跳过，返回实际插入的行数，用于可以重复执行的导入
+// MySQL 下 DoNothing 生成的不是 INSERT IGNORE，而是把主键更新为自身：
+// INSERT INTO `t_users` (...) VALUES (...),(...) ON DUPLICATE KEY UPDATE `id`=`id`
+// 值没有变化的行 RowsAffected 计 0，所以返回的就是新插入的行数；INSERT IGNORE 还会把数据过长等错误降级为警告，这里不会
+// 有冲突时 users 中回填的主键可能不准确，需要主键时应重新查询
+func insertIgnore(gormDb *gorm.DB, users []User) (int64, error) {
+	if len(users) == 0 {
+		return 0, nil
+	}
+	result := gormDb.Clauses(clause.OnConflict{DoNothing: true}).Create(&users)
+	return result.RowsAffected, result.Error
+}
+
+func testInsertIgnore(gormDb *gorm.DB) {
+	// 每次运行使用不同的 Email，演示可以重复执行
+	emails := make([]string, 4)
+	for i := range emails {
+		emails[i] = fmt.Sprintf("sharpe-ignore-%d-%d@gmail.com", time.Now().UnixNano(), i)
+	}
+	existing := []User{
+		{Name: "sharpe-ignore-0", Email: &emails[0]},
+		{Name: "sharpe-ignore-1", Email: &emails[1]},
+	}
+	if err := gormDb.Create(&existing).Error; err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	// 前两个与已有的 Email 冲突
+	users := []User{
+		{Name: "sharpe-ignore-0", Email: &emails[0]},
+		{Name: "sharpe-ignore-1", Email: &emails[1]},
+		{Name: "sharpe-ignore-2", Email: &emails[2]},
+		{Name: "sharpe-ignore-3", Email: &emails[3]},
+	}
+	rows, err := insertIgnore(gormDb, users)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if rows != 2 {
+		fmt.Printf("expect 2 rows inserted, got %d\n", rows)
+		return
+	}
+
+	// 再执行一次全部跳过
+	if rows, err = insertIgnore(gormDb, users); err != nil || rows != 0 {
+		fmt.Printf("expect 0 rows inserted, got %d, %v\n", rows, err)
+		return
+	}
+}